@@ -0,0 +1,496 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RoutingStrategy selects which healthy backend in a BackendGroup serves the next request.
+type RoutingStrategy string
+
+const (
+	StrategyRoundRobin RoutingStrategy = "round-robin"
+	StrategyPriority   RoutingStrategy = "priority"
+	StrategyConsensus  RoutingStrategy = "consensus"
+)
+
+const (
+	circuitBreakerThreshold  = 3                      // consecutive failures before a backend is tripped
+	circuitBreakerBaseDelay  = 500 * time.Millisecond // initial backoff once tripped
+	circuitBreakerMaxDelay   = 30 * time.Second       // cap on exponential backoff
+	defaultConsensusMaxLag   = 3                      // blocks a backend may lag the safe head under StrategyConsensus
+	defaultHealthCheckPeriod = 5 * time.Second
+	backendRequestTimeout    = 10 * time.Second
+)
+
+// BackendConfig describes a single upstream RPC node, as configured for a BackendGroup.
+type BackendConfig struct {
+	Name     string
+	Url      string
+	WsUrl    string // upstream websocket endpoint, used for eth_subscribe fan-in; empty disables WS for this backend
+	Weight   int    // higher is preferred more often under StrategyRoundRobin
+	Priority int    // lower is tried first under StrategyPriority
+
+	// RateLimitPerSec and RateLimitBurst bound how many requests this backend may receive per second. Zero means
+	// unlimited.
+	RateLimitPerSec float64
+	RateLimitBurst  int
+
+	// MaxBlocksBehind is only used under StrategyConsensus: how far behind the group's safe head this backend may
+	// lag before reads are no longer routed to it. Zero uses defaultConsensusMaxLag.
+	MaxBlocksBehind int
+}
+
+// Backend is a single upstream RPC node tracked by a BackendGroup, including its live health state.
+type Backend struct {
+	BackendConfig
+
+	client  *http.Client
+	limiter *tokenBucket
+
+	mu                  sync.Mutex
+	healthy             bool
+	consecutiveFailures int
+	backoffUntil        time.Time
+	latestBlock         uint64
+}
+
+func newBackend(cfg BackendConfig) *Backend {
+	b := &Backend{
+		BackendConfig: cfg,
+		client:        &http.Client{Timeout: backendRequestTimeout},
+		healthy:       true,
+	}
+	if cfg.RateLimitPerSec > 0 {
+		b.limiter = newTokenBucket(cfg.RateLimitPerSec, cfg.RateLimitBurst)
+	}
+	return b
+}
+
+// String identifies the backend for logs by its configured name, e.g. "infura"; the "<group>/<backend>" form is built
+// by BackendGroup.Forward.
+func (b *Backend) String() string {
+	return b.Name
+}
+
+// available reports whether the backend's circuit breaker currently allows traffic and whether it has rate-limit
+// budget left. When the circuit breaker allows traffic and a rate limit is configured, the check itself takes one
+// token from the backend's limiter.
+func (b *Backend) available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.healthy && time.Now().Before(b.backoffUntil) {
+		return false
+	}
+	if b.limiter != nil && !b.limiter.Allow() {
+		return false
+	}
+	return true
+}
+
+func (b *Backend) blocksBehind(safeHead uint64) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.latestBlock >= safeHead {
+		return 0
+	}
+	return safeHead - b.latestBlock
+}
+
+func (b *Backend) recordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.healthy = true
+	b.consecutiveFailures = 0
+}
+
+func (b *Backend) recordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.consecutiveFailures++
+	if b.consecutiveFailures >= circuitBreakerThreshold {
+		b.healthy = false
+		backoff := circuitBreakerBaseDelay * time.Duration(math.Pow(2, float64(b.consecutiveFailures-circuitBreakerThreshold)))
+		if backoff > circuitBreakerMaxDelay {
+			backoff = circuitBreakerMaxDelay
+		}
+		b.backoffUntil = time.Now().Add(backoff)
+	}
+}
+
+func (b *Backend) setLatestBlock(blockNum uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.latestBlock = blockNum
+}
+
+// BackendGroup is a named pool of upstream RPC backends (e.g. "primary", "protected") that are routed across
+// according to a RoutingStrategy, following the proxyd model of health-checked, weighted backend pools.
+type BackendGroup struct {
+	Name     string
+	Strategy RoutingStrategy
+	Backends []*Backend
+
+	rrMu    sync.Mutex
+	rrIndex int
+
+	headMu   sync.RWMutex
+	safeHead uint64
+}
+
+// NewBackendGroup creates a BackendGroup that routes across backends according to strategy. Callers should call
+// StartHealthChecks to begin background health/consensus polling.
+func NewBackendGroup(name string, strategy RoutingStrategy, configs []BackendConfig) *BackendGroup {
+	backends := make([]*Backend, 0, len(configs))
+	for _, cfg := range configs {
+		backends = append(backends, newBackend(cfg))
+	}
+	return &BackendGroup{
+		Name:     name,
+		Strategy: strategy,
+		Backends: backends,
+	}
+}
+
+// NewSingleBackendGroup wraps a single URL as a one-backend group, e.g. for the custom "?url=" override or for a
+// TxManager that doesn't need a pool.
+func NewSingleBackendGroup(name string, url string) *BackendGroup {
+	return NewBackendGroup(name, StrategyPriority, []BackendConfig{{Name: name, Url: url}})
+}
+
+// StartHealthChecks launches a background goroutine that periodically pings every backend with eth_blockNumber,
+// updates its health and latest-known block, and (for StrategyConsensus groups) recomputes the group's safe head.
+// It returns a cancel func the caller should invoke on shutdown.
+func (bg *BackendGroup) StartHealthChecks(period time.Duration) (stop func()) {
+	if period <= 0 {
+		period = defaultHealthCheckPeriod
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				bg.pollOnce(ctx)
+			}
+		}
+	}()
+	return cancel
+}
+
+func (bg *BackendGroup) pollOnce(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, b := range bg.Backends {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			blockNum, err := fetchBlockNumber(ctx, b)
+			if err != nil {
+				b.recordFailure()
+				return
+			}
+			b.recordSuccess()
+			b.setLatestBlock(blockNum)
+		}(b)
+	}
+	wg.Wait()
+
+	if bg.Strategy != StrategyConsensus {
+		return
+	}
+
+	// The safe head is the highest block number reported by any currently-healthy backend; other backends are
+	// considered behind it and excluded from read routing once they lag past their MaxBlocksBehind.
+	var safeHead uint64
+	for _, b := range bg.Backends {
+		if !b.available() {
+			continue
+		}
+		b.mu.Lock()
+		if b.latestBlock > safeHead {
+			safeHead = b.latestBlock
+		}
+		b.mu.Unlock()
+	}
+	bg.headMu.Lock()
+	bg.safeHead = safeHead
+	bg.headMu.Unlock()
+}
+
+func (bg *BackendGroup) getSafeHead() uint64 {
+	bg.headMu.RLock()
+	defer bg.headMu.RUnlock()
+	return bg.safeHead
+}
+
+// Forward picks a healthy backend according to the group's strategy and proxies body to it, returning the response
+// body, the upstream HTTP status code and the name of the backend that served the request. If the chosen backend
+// fails, the next eligible one is tried until the candidate list is exhausted. isWrite should be true for mutating
+// calls (eth_sendRawTransaction); under StrategyConsensus it exempts the backend from the lag filter applied to
+// reads, since a backend merely slow to report its latest block is still fine to submit transactions to.
+func (bg *BackendGroup) Forward(ctx context.Context, body []byte, isWrite bool) (respBody []byte, statusCode int, servedBy string, err error) {
+	candidates := bg.candidates(isWrite)
+	if len(candidates) == 0 {
+		return nil, 0, "", fmt.Errorf("backend group %q has no eligible backends", bg.Name)
+	}
+
+	var lastErr error
+	for _, b := range candidates {
+		respBody, statusCode, err = forwardToBackend(ctx, b, body)
+		if err != nil {
+			lastErr = err
+			b.recordFailure()
+			continue
+		}
+		if statusCode < 200 || statusCode >= 300 {
+			lastErr = fmt.Errorf("backend %q: unexpected status %d", b.Name, statusCode)
+			b.recordFailure()
+			continue
+		}
+		b.recordSuccess()
+		return respBody, statusCode, fmt.Sprintf("%s/%s", bg.Name, b.Name), nil
+	}
+	return nil, 0, "", fmt.Errorf("backend group %q: all backends failed: %w", bg.Name, lastErr)
+}
+
+// candidates returns the backends eligible to serve the next request, in the order they should be tried. The
+// StrategyConsensus lag filter only applies when !isWrite: it exists to refuse routing reads to a backend that's
+// fallen behind the group's safe head, not to fail eth_sendRawTransaction during a benign head-tracking lag.
+func (bg *BackendGroup) candidates(isWrite bool) []*Backend {
+	eligible := make([]*Backend, 0, len(bg.Backends))
+	safeHead := bg.getSafeHead()
+	for _, b := range bg.Backends {
+		if !b.available() {
+			continue
+		}
+		if !isWrite && bg.Strategy == StrategyConsensus && safeHead > 0 {
+			maxLag := uint64(b.MaxBlocksBehind)
+			if maxLag == 0 {
+				maxLag = defaultConsensusMaxLag
+			}
+			if b.blocksBehind(safeHead) > maxLag {
+				continue
+			}
+		}
+		eligible = append(eligible, b)
+	}
+
+	switch bg.Strategy {
+	case StrategyPriority:
+		sort.SliceStable(eligible, func(i, j int) bool { return eligible[i].Priority < eligible[j].Priority })
+		return eligible
+	default: // StrategyRoundRobin and StrategyConsensus both round-robin among the eligible set
+		return bg.roundRobinOrder(eligible)
+	}
+}
+
+// roundRobinOrder rotates eligible so repeated calls spread load, weighting backends that declare a larger Weight
+// by giving them more of the rotation's starting positions.
+func (bg *BackendGroup) roundRobinOrder(eligible []*Backend) []*Backend {
+	if len(eligible) <= 1 {
+		return eligible
+	}
+
+	weighted := make([]*Backend, 0, len(eligible))
+	for _, b := range eligible {
+		weight := b.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		for i := 0; i < weight; i++ {
+			weighted = append(weighted, b)
+		}
+	}
+
+	bg.rrMu.Lock()
+	start := bg.rrIndex % len(weighted)
+	bg.rrIndex++
+	bg.rrMu.Unlock()
+
+	ordered := make([]*Backend, 0, len(eligible))
+	seen := make(map[*Backend]bool, len(eligible))
+	for i := 0; i < len(weighted); i++ {
+		b := weighted[(start+i)%len(weighted)]
+		if seen[b] {
+			continue
+		}
+		seen[b] = true
+		ordered = append(ordered, b)
+	}
+	return ordered
+}
+
+// wsBackend returns the first eligible backend in the group that has a websocket endpoint configured, for
+// subscription fan-in. Subscriptions are a read path, so the consensus lag filter applies.
+func (bg *BackendGroup) wsBackend() (*Backend, bool) {
+	for _, b := range bg.candidates(false) {
+		if b.WsUrl != "" {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+func forwardToBackend(ctx context.Context, b *Backend, body []byte) (respBody []byte, statusCode int, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.Url, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	return respBody, resp.StatusCode, nil
+}
+
+func fetchBlockNumber(ctx context.Context, b *Backend) (uint64, error) {
+	reqBody, _ := json.Marshal(JsonRpcRequest{Version: "2.0", Id: 1, Method: "eth_blockNumber"})
+	respBody, statusCode, err := forwardToBackend(ctx, b, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	if statusCode != http.StatusOK {
+		return 0, fmt.Errorf("eth_blockNumber: unexpected status %d", statusCode)
+	}
+
+	var rpcResp JsonRpcResponse
+	if err := json.Unmarshal(respBody, &rpcResp); err != nil {
+		return 0, err
+	}
+	if rpcResp.Error != nil {
+		return 0, fmt.Errorf("eth_blockNumber: %s", rpcResp.Error.Message)
+	}
+
+	hexBlock, ok := rpcResp.Result.(string)
+	if !ok {
+		return 0, fmt.Errorf("eth_blockNumber: unexpected result type %T", rpcResp.Result)
+	}
+	var blockNum uint64
+	if _, err := fmt.Sscanf(hexBlock, "0x%x", &blockNum); err != nil {
+		return 0, fmt.Errorf("eth_blockNumber: invalid hex block %q", hexBlock)
+	}
+	return blockNum, nil
+}
+
+// fetchTransactionCount forwards an eth_getTransactionCount call through bg, used to pre-compute the nonce MetaMask
+// should be told about after a bundle has failed too many times.
+func fetchTransactionCount(ctx context.Context, bg *BackendGroup, address string) (uint64, error) {
+	reqBody, _ := json.Marshal(JsonRpcRequest{
+		Version: "2.0",
+		Id:      1,
+		Method:  "eth_getTransactionCount",
+		Params:  []interface{}{address, "latest"},
+	})
+
+	respBody, statusCode, _, err := bg.Forward(ctx, reqBody, false)
+	if err != nil {
+		return 0, err
+	}
+	if statusCode != http.StatusOK {
+		return 0, fmt.Errorf("eth_getTransactionCount: unexpected status %d", statusCode)
+	}
+
+	var rpcResp JsonRpcResponse
+	if err := json.Unmarshal(respBody, &rpcResp); err != nil {
+		return 0, err
+	}
+	if rpcResp.Error != nil {
+		return 0, fmt.Errorf("eth_getTransactionCount: %s", rpcResp.Error.Message)
+	}
+
+	hexCount, ok := rpcResp.Result.(string)
+	if !ok {
+		return 0, fmt.Errorf("eth_getTransactionCount: unexpected result type %T", rpcResp.Result)
+	}
+	var count uint64
+	if _, err := fmt.Sscanf(hexCount, "0x%x", &count); err != nil {
+		return 0, fmt.Errorf("eth_getTransactionCount: invalid hex count %q", hexCount)
+	}
+	return count, nil
+}
+
+// tokenBucket is a minimal fixed-rate, bursty token bucket used for per-backend rate limiting.
+type tokenBucket struct {
+	mu         sync.Mutex
+	rate       float64 // tokens added per second
+	burst      float64 // bucket capacity
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:       rate,
+		burst:      float64(burst),
+		tokens:     float64(burst),
+		lastRefill: time.Now(),
+	}
+}
+
+func (t *tokenBucket) Allow() bool {
+	return t.AllowN(1)
+}
+
+// AllowN debits n tokens atomically, refilling for elapsed time first. It reports whether n tokens were available.
+func (t *tokenBucket) AllowN(n float64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.refillLocked()
+	if t.tokens < n {
+		return false
+	}
+	t.tokens -= n
+	return true
+}
+
+// wouldAllow reports whether n tokens are currently available, without debiting them.
+func (t *tokenBucket) wouldAllow(n float64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.refillLocked()
+	return t.tokens >= n
+}
+
+// retryAfter reports how long a caller should wait before n tokens become available, without debiting anything.
+func (t *tokenBucket) retryAfter(n float64) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.refillLocked()
+	deficit := n - t.tokens
+	if deficit <= 0 || t.rate <= 0 {
+		return 0
+	}
+	return time.Duration(deficit / t.rate * float64(time.Second))
+}
+
+func (t *tokenBucket) refillLocked() {
+	now := time.Now()
+	elapsed := now.Sub(t.lastRefill).Seconds()
+	t.lastRefill = now
+	t.tokens = math.Min(t.burst, t.tokens+elapsed*t.rate)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBackendGroupForwardFailsOverOnNon2xxStatus(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	var healthyHits int
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyHits++
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer healthy.Close()
+
+	bg := NewBackendGroup("test", StrategyPriority, []BackendConfig{
+		{Name: "failing", Url: failing.URL, Priority: 0},
+		{Name: "healthy", Url: healthy.URL, Priority: 1},
+	})
+
+	respBody, statusCode, servedBy, err := bg.Forward(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_call"}`), false)
+	if err != nil {
+		t.Fatalf("Forward returned error: %v", err)
+	}
+	if statusCode != http.StatusOK {
+		t.Fatalf("statusCode = %d, want %d", statusCode, http.StatusOK)
+	}
+	if servedBy != "test/healthy" {
+		t.Fatalf("servedBy = %q, want %q", servedBy, "test/healthy")
+	}
+	if healthyHits != 1 {
+		t.Fatalf("healthy backend hit %d times, want 1", healthyHits)
+	}
+	if len(respBody) == 0 {
+		t.Fatal("expected a non-empty response body")
+	}
+}
+
+func TestBackendGroupForwardTripsCircuitBreakerOnRepeatedNon2xx(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	bg := NewBackendGroup("test", StrategyPriority, []BackendConfig{
+		{Name: "failing", Url: failing.URL},
+	})
+	b := bg.Backends[0]
+
+	for i := 0; i < circuitBreakerThreshold; i++ {
+		if b.available() != true {
+			t.Fatalf("backend unexpectedly unavailable before threshold reached (iteration %d)", i)
+		}
+		if _, _, _, err := bg.Forward(context.Background(), []byte(`{}`), false); err == nil {
+			t.Fatal("expected Forward to fail against an always-503 backend")
+		}
+	}
+
+	if b.available() {
+		t.Fatal("expected circuit breaker to trip after circuitBreakerThreshold consecutive non-2xx responses")
+	}
+}
+
+func TestBackendGroupForwardAllBackendsFailing(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	bg := NewBackendGroup("test", StrategyPriority, []BackendConfig{
+		{Name: "failing", Url: failing.URL},
+	})
+
+	if _, _, _, err := bg.Forward(context.Background(), []byte(`{}`), false); err == nil {
+		t.Fatal("expected an error when every candidate backend fails")
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newBatchTestRequest(body string) (*RpcRequest, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	var respw http.ResponseWriter = rec
+	r := &RpcRequest{
+		respw: &respw,
+		body:  []byte(body),
+	}
+	return r, rec
+}
+
+func TestProcessBatchPreservesOrderAndDropsNotifications(t *testing.T) {
+	body := `[
+		{"jsonrpc":"2.0","id":1,"method":"net_version"},
+		{"jsonrpc":"2.0","method":"net_version"},
+		{"jsonrpc":"2.0","id":2,"method":"net_version"},
+		{"jsonrpc":"2.0","id":3,"method":"net_version"}
+	]`
+	r, rec := newBatchTestRequest(body)
+	r.processBatch()
+
+	var responses []JsonRpcResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("failed to unmarshal batch response: %v (body: %s)", err, rec.Body.String())
+	}
+
+	if len(responses) != 3 {
+		t.Fatalf("got %d responses, want 3 (notification should be dropped)", len(responses))
+	}
+
+	wantIds := []float64{1, 2, 3}
+	for i, resp := range responses {
+		gotId, ok := resp.Id.(float64)
+		if !ok || gotId != wantIds[i] {
+			t.Fatalf("response[%d].Id = %v, want %v (order not preserved)", i, resp.Id, wantIds[i])
+		}
+	}
+}
+
+func TestProcessBatchEmptyArrayReturnsSingleError(t *testing.T) {
+	r, rec := newBatchTestRequest(`[]`)
+	r.processBatch()
+
+	var resp JsonRpcResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v (body: %s)", err, rec.Body.String())
+	}
+	if resp.Error == nil {
+		t.Fatal("expected a JSON-RPC error for an empty batch, got none")
+	}
+}
+
+func TestProcessBatchAllNotificationsReturnsEmptyArray(t *testing.T) {
+	body := `[{"jsonrpc":"2.0","method":"net_version"},{"jsonrpc":"2.0","method":"net_version"}]`
+	r, rec := newBatchTestRequest(body)
+	r.processBatch()
+
+	var responses []JsonRpcResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("failed to unmarshal batch response: %v (body: %s)", err, rec.Body.String())
+	}
+	if len(responses) != 0 {
+		t.Fatalf("got %d responses, want 0 (all entries were notifications)", len(responses))
+	}
+}
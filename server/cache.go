@@ -0,0 +1,276 @@
+package server
+
+import (
+	"container/list"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cacheableReadMethods lists the idempotent read methods eligible for response caching. Anything not in this set
+// (eth_sendRawTransaction, eth_sendTransaction, subscriptions, etc.) always bypasses the cache.
+var cacheableReadMethods = map[string]bool{
+	"eth_call":                  true,
+	"eth_getBalance":            true,
+	"eth_getCode":               true,
+	"eth_chainId":               true,
+	"net_version":               true,
+	"eth_getTransactionReceipt": true,
+	"eth_getBlockByNumber":      true,
+}
+
+// CacheConfig configures a ResponseCache.
+type CacheConfig struct {
+	MaxSizeBytes int64                    // approximate serialized-JSON byte budget before LRU eviction kicks in
+	DefaultTTL   time.Duration            // used when MethodTTLs has no entry for the request's method
+	MethodTTLs   map[string]time.Duration // per-method TTL overrides, e.g. longer for eth_chainId
+}
+
+// CacheStats is a point-in-time snapshot of a ResponseCache's counters, suitable for exposing as Prometheus gauges.
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Bytes     int64
+}
+
+// cacheLookup is the result of canonicalizing a JSON-RPC request into a cache key. Computing it once and passing it
+// to both Get and Put avoids resolving the block tag twice per request.
+type cacheLookup struct {
+	key      string
+	eligible bool
+	ttl      time.Duration
+}
+
+type cacheEntry struct {
+	key       string
+	resp      *JsonRpcResponse
+	size      int64
+	expiresAt time.Time
+}
+
+// ResponseCache is a bounded, size-weighted LRU cache of JSON-RPC responses for idempotent read methods, keyed by
+// (method, canonicalized params, block-tag-resolved-to-number). It sits between RpcRequest.dispatch and
+// proxyRequestRead.
+type ResponseCache struct {
+	cfg CacheConfig
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element // key -> element of lru, value is *cacheEntry
+	lru      *list.List               // front = most recently used
+	curBytes int64
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// NewResponseCache creates a ResponseCache. A MaxSizeBytes of zero disables eviction (not recommended in production).
+func NewResponseCache(cfg CacheConfig) *ResponseCache {
+	return &ResponseCache{
+		cfg:     cfg,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// prepare canonicalizes a JSON-RPC request into a cacheLookup. Requests referencing pending state, containing a
+// state-override argument, or an unresolvable "latest" block tag come back with eligible=false.
+func (c *ResponseCache) prepare(jsonReq *JsonRpcRequest, backends *BackendGroup) cacheLookup {
+	if !cacheableReadMethods[jsonReq.Method] {
+		return cacheLookup{}
+	}
+
+	var canonicalParams []interface{}
+	switch jsonReq.Method {
+	case "eth_call":
+		// A third param is a state-override object; anything that mutates the EVM's view of state must bypass.
+		if len(jsonReq.Params) != 2 {
+			return cacheLookup{}
+		}
+		resolved, ok := resolveBlockTagParam(jsonReq.Params[1], backends)
+		if !ok {
+			return cacheLookup{}
+		}
+		canonicalParams = []interface{}{jsonReq.Params[0], resolved}
+
+	case "eth_getBalance", "eth_getCode":
+		if len(jsonReq.Params) != 2 {
+			return cacheLookup{}
+		}
+		resolved, ok := resolveBlockTagParam(jsonReq.Params[1], backends)
+		if !ok {
+			return cacheLookup{}
+		}
+		canonicalParams = []interface{}{jsonReq.Params[0], resolved}
+
+	case "eth_getBlockByNumber":
+		// Only a concrete block number is cacheable here; "latest"/"pending" are excluded even though
+		// resolveBlockTagParam could resolve "latest", since the caller explicitly asked for the moving head.
+		if len(jsonReq.Params) < 1 {
+			return cacheLookup{}
+		}
+		tag, ok := jsonReq.Params[0].(string)
+		if !ok || !strings.HasPrefix(tag, "0x") {
+			return cacheLookup{}
+		}
+		canonicalParams = jsonReq.Params
+
+	case "eth_getTransactionReceipt":
+		if len(jsonReq.Params) != 1 {
+			return cacheLookup{}
+		}
+		canonicalParams = jsonReq.Params
+
+	case "eth_chainId", "net_version":
+		canonicalParams = nil
+
+	default:
+		return cacheLookup{}
+	}
+
+	keyBytes, err := json.Marshal(struct {
+		Method string
+		Params []interface{}
+	}{jsonReq.Method, canonicalParams})
+	if err != nil {
+		return cacheLookup{}
+	}
+
+	ttl := c.cfg.DefaultTTL
+	if methodTTL, ok := c.cfg.MethodTTLs[jsonReq.Method]; ok {
+		ttl = methodTTL
+	}
+
+	return cacheLookup{key: string(keyBytes), eligible: true, ttl: ttl}
+}
+
+// resolveBlockTagParam resolves a block-tag request param to a concrete block number string. "pending" and
+// anything that isn't a recognized tag or hex number always fail to resolve (bypass the cache); "latest" is
+// resolved against the backend group's consensus safe head.
+func resolveBlockTagParam(param interface{}, backends *BackendGroup) (string, bool) {
+	tag, ok := param.(string)
+	if !ok {
+		return "", false
+	}
+
+	switch tag {
+	case "pending":
+		return "", false
+	case "latest":
+		if backends == nil {
+			return "", false
+		}
+		safeHead := backends.getSafeHead()
+		if safeHead == 0 {
+			return "", false
+		}
+		return fmtBlockNum(safeHead), true
+	case "earliest":
+		return tag, true
+	default:
+		if strings.HasPrefix(tag, "0x") {
+			return tag, true
+		}
+		return "", false
+	}
+}
+
+func fmtBlockNum(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
+
+// Get looks up lookup.key, returning the cached response (with its original request id still attached to the copy
+// the caller owns) and whether it was a hit. Expired entries are evicted and counted as a miss.
+func (c *ResponseCache) Get(lookup cacheLookup) (*JsonRpcResponse, bool) {
+	if !lookup.eligible {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[lookup.key]
+	if !ok {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.removeElement(el)
+		atomic.AddInt64(&c.misses, 1)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	atomic.AddInt64(&c.hits, 1)
+
+	respCopy := *entry.resp
+	return &respCopy, true
+}
+
+// Put stores resp under lookup.key, approximating its weight as its serialized JSON size, and evicts
+// least-recently-used entries until the cache is back under MaxSizeBytes.
+func (c *ResponseCache) Put(lookup cacheLookup, resp *JsonRpcResponse) {
+	if !lookup.eligible || resp.Error != nil {
+		return
+	}
+
+	serialized, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	size := int64(len(serialized))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[lookup.key]; ok {
+		c.removeElement(el)
+	}
+
+	respCopy := *resp
+	entry := &cacheEntry{
+		key:       lookup.key,
+		resp:      &respCopy,
+		size:      size,
+		expiresAt: time.Now().Add(lookup.ttl),
+	}
+	el := c.lru.PushFront(entry)
+	c.entries[lookup.key] = el
+	c.curBytes += size
+
+	for c.cfg.MaxSizeBytes > 0 && c.curBytes > c.cfg.MaxSizeBytes {
+		back := c.lru.Back()
+		if back == nil {
+			break
+		}
+		c.removeElement(back)
+		atomic.AddInt64(&c.evictions, 1)
+	}
+}
+
+// removeElement must be called with c.mu held.
+func (c *ResponseCache) removeElement(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.lru.Remove(el)
+	c.curBytes -= entry.size
+}
+
+// Stats returns a snapshot of the cache's counters for Prometheus.
+func (c *ResponseCache) Stats() CacheStats {
+	c.mu.Lock()
+	bytes := c.curBytes
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      atomic.LoadInt64(&c.hits),
+		Misses:    atomic.LoadInt64(&c.misses),
+		Evictions: atomic.LoadInt64(&c.evictions),
+		Bytes:     bytes,
+	}
+}
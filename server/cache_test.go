@@ -0,0 +1,100 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResponseCacheResolvesLatestViaSafeHead(t *testing.T) {
+	bg := &BackendGroup{Strategy: StrategyConsensus}
+	bg.headMu.Lock()
+	bg.safeHead = 100
+	bg.headMu.Unlock()
+
+	cache := NewResponseCache(CacheConfig{DefaultTTL: time.Minute})
+	jsonReq := &JsonRpcRequest{Method: "eth_getBalance", Params: []interface{}{"0xabc", "latest"}}
+
+	lookup := cache.prepare(jsonReq, bg)
+	if !lookup.eligible {
+		t.Fatal("expected eth_getBalance at \"latest\" to be eligible once the backend group has a safe head")
+	}
+
+	// "latest" must resolve to the concrete safe-head block number, so a request against an explicit 0x64 (100)
+	// hits the same cache entry.
+	explicitReq := &JsonRpcRequest{Method: "eth_getBalance", Params: []interface{}{"0xabc", "0x64"}}
+	explicitLookup := cache.prepare(explicitReq, bg)
+	if lookup.key != explicitLookup.key {
+		t.Fatalf("lookup key for \"latest\" = %q, want it to match the resolved block number's key %q", lookup.key, explicitLookup.key)
+	}
+}
+
+func TestResponseCacheBypassesPendingAndStateOverride(t *testing.T) {
+	bg := &BackendGroup{Strategy: StrategyConsensus}
+	bg.headMu.Lock()
+	bg.safeHead = 100
+	bg.headMu.Unlock()
+
+	cache := NewResponseCache(CacheConfig{DefaultTTL: time.Minute})
+
+	pendingReq := &JsonRpcRequest{Method: "eth_getBalance", Params: []interface{}{"0xabc", "pending"}}
+	if lookup := cache.prepare(pendingReq, bg); lookup.eligible {
+		t.Fatal("expected a \"pending\" block tag to bypass the cache")
+	}
+
+	stateOverrideReq := &JsonRpcRequest{
+		Method: "eth_call",
+		Params: []interface{}{map[string]interface{}{"to": "0xabc"}, "latest", map[string]interface{}{"0xabc": map[string]interface{}{"balance": "0x1"}}},
+	}
+	if lookup := cache.prepare(stateOverrideReq, bg); lookup.eligible {
+		t.Fatal("expected an eth_call with a state-override third param to bypass the cache")
+	}
+}
+
+func TestResponseCacheEvictsExpiredEntryOnGet(t *testing.T) {
+	cache := NewResponseCache(CacheConfig{DefaultTTL: time.Minute})
+	lookup := cache.prepare(&JsonRpcRequest{Method: "eth_chainId"}, nil)
+	if !lookup.eligible {
+		t.Fatal("expected eth_chainId to be cache-eligible")
+	}
+
+	cache.Put(lookup, &JsonRpcResponse{Version: "2.0", Result: "0x1"})
+	if _, hit := cache.Get(lookup); !hit {
+		t.Fatal("expected a cache hit immediately after Put")
+	}
+
+	// Backdate the entry past its TTL without a real sleep.
+	cache.mu.Lock()
+	el := cache.entries[lookup.key]
+	el.Value.(*cacheEntry).expiresAt = time.Now().Add(-time.Second)
+	cache.mu.Unlock()
+
+	if _, hit := cache.Get(lookup); hit {
+		t.Fatal("expected the expired entry to be evicted and reported as a miss")
+	}
+	if _, ok := cache.entries[lookup.key]; ok {
+		t.Fatal("expected the expired entry to be removed from the cache after Get")
+	}
+	if stats := cache.Stats(); stats.Misses != 1 {
+		t.Fatalf("Misses = %d, want 1 after a TTL-expired Get", stats.Misses)
+	}
+}
+
+func TestResponseCachePutEvictsLeastRecentlyUsedOnceOverSize(t *testing.T) {
+	cache := NewResponseCache(CacheConfig{DefaultTTL: time.Minute, MaxSizeBytes: 40})
+
+	first := cache.prepare(&JsonRpcRequest{Method: "eth_chainId"}, nil)
+	cache.Put(first, &JsonRpcResponse{Version: "2.0", Result: "0x1"})
+
+	second := cache.prepare(&JsonRpcRequest{Method: "net_version"}, nil)
+	cache.Put(second, &JsonRpcResponse{Version: "2.0", Result: "1"})
+
+	if _, hit := cache.Get(first); hit {
+		t.Fatal("expected the least-recently-used entry to have been evicted once the cache exceeded MaxSizeBytes")
+	}
+	if _, hit := cache.Get(second); !hit {
+		t.Fatal("expected the most recently put entry to survive eviction")
+	}
+	if stats := cache.Stats(); stats.Evictions != 1 {
+		t.Fatalf("Evictions = %d, want 1 after a size-triggered eviction", stats.Evictions)
+	}
+}
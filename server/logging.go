@@ -0,0 +1,44 @@
+package server
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+)
+
+// logger is the package-wide structured logger. Every line carries whatever fields the call site attaches (uid, ip,
+// method, backend, duration_ms, trace_id), replacing the free-form log.Printf lines this package used to write.
+var logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
+
+// log writes an info-level structured line for r, merging r's standard fields (uid, ip, method, backend, trace_id)
+// with a message formatted from format/v exactly like the log.Printf calls it replaces.
+func (r *RpcRequest) log(format string, v ...interface{}) {
+	r.logEvent(logger.Info(), format, v...)
+}
+
+// logError writes an error-level structured line for r, merging r's standard fields with the formatted message.
+func (r *RpcRequest) logError(format string, v ...interface{}) {
+	r.logEvent(logger.Error(), format, v...)
+}
+
+// logDuration writes an info-level structured line for r with an additional duration_ms field, for the two call
+// sites (request latency, upstream proxy latency) that need it reported as a number rather than baked into the
+// message text.
+func (r *RpcRequest) logDuration(d interface{ Milliseconds() int64 }, format string, v ...interface{}) {
+	r.logEvent(logger.Info().Int64("duration_ms", d.Milliseconds()), format, v...)
+}
+
+func (r *RpcRequest) logEvent(event *zerolog.Event, format string, v ...interface{}) {
+	method := ""
+	if r.jsonReq != nil {
+		method = r.jsonReq.Method
+	}
+	event.
+		Str("uid", r.uid).
+		Str("ip", r.ip).
+		Str("method", method).
+		Str("backend", r.servedBy).
+		Str("trace_id", traceIDFromContext(r.context())).
+		Msg(fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the Prometheus collectors for an rpc-endpoint instance and exposes them via /metrics. A nil *Metrics
+// is valid everywhere it's used - every Record*/Observe* method is a no-op on a nil receiver - so metrics remain
+// entirely optional to wire up.
+type Metrics struct {
+	cache *ResponseCache // read on each /metrics scrape to derive the cache hit ratio gauge
+
+	registry *prometheus.Registry
+
+	requestsTotal        *prometheus.CounterVec
+	proxyDuration        *prometheus.HistogramVec
+	sendRawTxRouteTotal  *prometheus.CounterVec
+	ofacBlocksTotal      prometheus.Counter
+	blacklistedHitsTotal prometheus.Counter
+	mmNonceActivateTotal prometheus.Counter
+	cacheHitRatio        prometheus.Gauge
+}
+
+// NewMetrics creates a Metrics instance registered to a fresh Prometheus registry. cache may be nil if response
+// caching is disabled, in which case the cache hit ratio gauge always reports 0.
+func NewMetrics(cache *ResponseCache) *Metrics {
+	registry := prometheus.NewRegistry()
+
+	m := &Metrics{
+		cache:    cache,
+		registry: registry,
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rpcendpoint_requests_total",
+			Help: "Total JSON-RPC requests, by method and outcome.",
+		}, []string{"method", "outcome"}),
+		proxyDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rpcendpoint_proxy_duration_seconds",
+			Help:    "Latency of proxying a request to an upstream backend group.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"backend_group"}),
+		sendRawTxRouteTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rpcendpoint_sendrawtransaction_route_total",
+			Help: "eth_sendRawTransaction routing decisions, by target (mempool or Flashbots).",
+		}, []string{"target"}),
+		ofacBlocksTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rpcendpoint_ofac_blocks_total",
+			Help: "Transactions rejected because the sender is on the OFAC sanctions list.",
+		}),
+		blacklistedHitsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rpcendpoint_blacklisted_rawtx_hits_total",
+			Help: "eth_sendRawTransaction calls rejected because the raw tx is blacklisted.",
+		}),
+		mmNonceActivateTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rpcendpoint_mm_nonce_helper_activations_total",
+			Help: "Times the MetaMask nonce-helper blacklist was populated for a failing account.",
+		}),
+		cacheHitRatio: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "rpcendpoint_cache_hit_ratio",
+			Help: "Response cache hit ratio (hits / (hits+misses)) as of the last scrape.",
+		}),
+	}
+
+	registry.MustRegister(
+		m.requestsTotal,
+		m.proxyDuration,
+		m.sendRawTxRouteTotal,
+		m.ofacBlocksTotal,
+		m.blacklistedHitsTotal,
+		m.mmNonceActivateTotal,
+		m.cacheHitRatio,
+	)
+
+	return m
+}
+
+// Handler returns the http.Handler to mount at /metrics. It refreshes the cache hit ratio gauge on every scrape
+// rather than on a timer, since ResponseCache.Stats() is cheap and scrapes are infrequent.
+func (m *Metrics) Handler() http.Handler {
+	promHandler := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		m.refreshCacheHitRatio()
+		promHandler.ServeHTTP(w, req)
+	})
+}
+
+func (m *Metrics) RecordRequest(method, outcome string) {
+	if m == nil {
+		return
+	}
+	m.requestsTotal.WithLabelValues(method, outcome).Inc()
+}
+
+func (m *Metrics) ObserveProxyDuration(backendGroup string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.proxyDuration.WithLabelValues(backendGroup).Observe(d.Seconds())
+}
+
+func (m *Metrics) RecordSendRawTxRoute(target string) {
+	if m == nil {
+		return
+	}
+	m.sendRawTxRouteTotal.WithLabelValues(target).Inc()
+}
+
+func (m *Metrics) RecordOFACBlock() {
+	if m == nil {
+		return
+	}
+	m.ofacBlocksTotal.Inc()
+}
+
+func (m *Metrics) RecordBlacklistHit() {
+	if m == nil {
+		return
+	}
+	m.blacklistedHitsTotal.Inc()
+}
+
+func (m *Metrics) RecordMMNonceActivation() {
+	if m == nil {
+		return
+	}
+	m.mmNonceActivateTotal.Inc()
+}
+
+// refreshCacheHitRatio recomputes the cache hit ratio gauge from the current ResponseCache stats; called by Handler
+// immediately before every scrape.
+func (m *Metrics) refreshCacheHitRatio() {
+	if m == nil || m.cache == nil {
+		return
+	}
+	stats := m.cache.Stats()
+	total := stats.Hits + stats.Misses
+	if total == 0 {
+		m.cacheHitRatio.Set(0)
+		return
+	}
+	m.cacheHitRatio.Set(float64(stats.Hits) / float64(total))
+}
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTrackedBudgets bounds how many distinct IPs or API keys an InMemoryRateLimiter keeps a live budget for.
+// Without this, a client varying its IP/API key on every request could grow these maps without limit; the oldest
+// (least-recently-used) budget is evicted to make room, mirroring ResponseCache's LRU eviction in cache.go.
+const maxTrackedBudgets = 100_000
+
+// maxApiKeyLength bounds how much of a client-supplied API key extractApiKey keeps, so a client can't inflate
+// keyBudgets' memory footprint by sending an arbitrarily long Authorization header or query param.
+const maxApiKeyLength = 256
+
+// RateLimitDecision is the result of checking whether a request may proceed.
+type RateLimitDecision struct {
+	Allowed    bool
+	RetryAfter time.Duration // only meaningful when !Allowed
+}
+
+// RateLimiter decides whether a request from ip (optionally identified by apiKey) calling method may proceed,
+// debiting cost tokens from whichever budgets are configured. Implementations may be in-memory (single node) or
+// Redis-backed (horizontally scaled); see InMemoryRateLimiter and RedisRateLimiter.
+type RateLimiter interface {
+	Allow(ctx context.Context, ip string, apiKey string, method string, cost int) RateLimitDecision
+
+	// AllowBatch checks every method in methodCounts (JSON-RPC method name -> entry count) against ip/apiKey/each
+	// method's own budget atomically: either every dimension has capacity and all are debited together, or none are.
+	// This matters because a naive per-method loop calling Allow would debit a method's tokens as a side effect of
+	// checking it, so an early method succeeding in a batch that's ultimately rejected over a later method's budget
+	// would otherwise drain ip/apiKey tokens for a batch that never actually runs.
+	AllowBatch(ctx context.Context, ip string, apiKey string, methodCounts map[string]int) RateLimitDecision
+}
+
+// BucketConfig configures one rate-limit dimension (e.g. "per IP"). Rate is tokens added per second; Burst is the
+// bucket's capacity. BonusRate/BonusBurst describe a second, larger bucket that refills more slowly than the
+// primary one, so a client that occasionally bursts past its primary budget isn't immediately throttled - only one
+// that's sustained it gets throttled. A zero Rate means this dimension is unlimited.
+type BucketConfig struct {
+	Rate       float64
+	Burst      int
+	BonusRate  float64
+	BonusBurst int
+}
+
+// RateLimiterConfig configures an InMemoryRateLimiter or RedisRateLimiter. Method holds overrides for specific
+// JSON-RPC methods (e.g. a tighter budget for eth_sendRawTransaction than for eth_call); methods absent from Method
+// are unlimited at the per-method dimension.
+type RateLimiterConfig struct {
+	IP     BucketConfig
+	Key    BucketConfig
+	Method map[string]BucketConfig
+}
+
+// budget pairs a primary token bucket with an optional, larger/slower-refilling bonus bucket.
+type budget struct {
+	primary *tokenBucket
+	bonus   *tokenBucket
+}
+
+func newBudget(cfg BucketConfig) *budget {
+	b := &budget{primary: newTokenBucket(cfg.Rate, cfg.Burst)}
+	if cfg.BonusBurst > 0 {
+		b.bonus = newTokenBucket(cfg.BonusRate, cfg.BonusBurst)
+	}
+	return b
+}
+
+func (b *budget) allowN(n float64) bool {
+	if b.primary.AllowN(n) {
+		return true
+	}
+	return b.bonus != nil && b.bonus.AllowN(n)
+}
+
+// wouldAllowN reports whether n tokens are currently available, without debiting them.
+func (b *budget) wouldAllowN(n float64) bool {
+	if b.primary.wouldAllow(n) {
+		return true
+	}
+	return b.bonus != nil && b.bonus.wouldAllow(n)
+}
+
+func (b *budget) retryAfter(n float64) time.Duration {
+	retry := b.primary.retryAfter(n)
+	if b.bonus == nil {
+		return retry
+	}
+	if bonusRetry := b.bonus.retryAfter(n); bonusRetry < retry {
+		return bonusRetry
+	}
+	return retry
+}
+
+// lruBudgets is a size-bounded map of key -> *budget, evicting the least-recently-used entry once it grows past
+// maxTrackedBudgets. Used for the IP and API-key dimensions, whose keys come from client-controlled input and would
+// otherwise let a client grow the map without limit.
+type lruBudgets struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front = most recently used
+}
+
+type lruBudgetEntry struct {
+	key    string
+	budget *budget
+}
+
+func newLruBudgets() *lruBudgets {
+	return &lruBudgets{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// getOrCreate returns the budget for key, creating it from cfg if this is the first time key has been seen, and
+// evicting the least-recently-used entry if the map is at capacity.
+func (lb *lruBudgets) getOrCreate(key string, cfg BucketConfig) *budget {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if el, ok := lb.entries[key]; ok {
+		lb.order.MoveToFront(el)
+		return el.Value.(*lruBudgetEntry).budget
+	}
+
+	b := newBudget(cfg)
+	el := lb.order.PushFront(&lruBudgetEntry{key: key, budget: b})
+	lb.entries[key] = el
+
+	if len(lb.entries) > maxTrackedBudgets {
+		oldest := lb.order.Back()
+		if oldest != nil {
+			lb.order.Remove(oldest)
+			delete(lb.entries, oldest.Value.(*lruBudgetEntry).key)
+		}
+	}
+
+	return b
+}
+
+// InMemoryRateLimiter enforces per-IP, per-API-key and per-method token-bucket budgets within a single process. The
+// method dimension is keyed by a bounded, server-configured set of method names (RateLimiterConfig.Method), so it
+// uses a plain map; IP and API-key are client-controlled and use the size-bounded lruBudgets instead.
+type InMemoryRateLimiter struct {
+	cfg RateLimiterConfig
+
+	ipBudgets  *lruBudgets
+	keyBudgets *lruBudgets
+
+	methodMu      sync.Mutex
+	methodBudgets map[string]*budget
+}
+
+func NewInMemoryRateLimiter(cfg RateLimiterConfig) *InMemoryRateLimiter {
+	return &InMemoryRateLimiter{
+		cfg:           cfg,
+		ipBudgets:     newLruBudgets(),
+		keyBudgets:    newLruBudgets(),
+		methodBudgets: make(map[string]*budget),
+	}
+}
+
+func (l *InMemoryRateLimiter) Allow(_ context.Context, ip string, apiKey string, method string, cost int) RateLimitDecision {
+	n := float64(cost)
+	if n <= 0 {
+		n = 1
+	}
+
+	if dec := l.checkLru(l.ipBudgets, ip, l.cfg.IP, n); !dec.Allowed {
+		return dec
+	}
+	if apiKey != "" {
+		if dec := l.checkLru(l.keyBudgets, apiKey, l.cfg.Key, n); !dec.Allowed {
+			return dec
+		}
+	}
+	if method != "" {
+		if methodCfg, ok := l.cfg.Method[method]; ok {
+			if dec := l.checkMethod(method, methodCfg, n); !dec.Allowed {
+				return dec
+			}
+		}
+	}
+	return RateLimitDecision{Allowed: true}
+}
+
+func (l *InMemoryRateLimiter) checkLru(lb *lruBudgets, key string, cfg BucketConfig, n float64) RateLimitDecision {
+	if cfg.Rate <= 0 {
+		return RateLimitDecision{Allowed: true} // unconfigured dimension is unlimited
+	}
+	return evaluate(lb.getOrCreate(key, cfg), n)
+}
+
+func (l *InMemoryRateLimiter) checkMethod(method string, cfg BucketConfig, n float64) RateLimitDecision {
+	if cfg.Rate <= 0 {
+		return RateLimitDecision{Allowed: true}
+	}
+	return evaluate(l.methodBudget(method, cfg), n)
+}
+
+// methodBudget returns the budget for method, creating it from cfg if this is the first time method has been
+// checked. Unlike lruBudgets, this map is never evicted: method is server-configured (RateLimiterConfig.Method), not
+// client-controlled, so its cardinality is bounded by the operator, not by traffic.
+func (l *InMemoryRateLimiter) methodBudget(method string, cfg BucketConfig) *budget {
+	l.methodMu.Lock()
+	defer l.methodMu.Unlock()
+
+	b, ok := l.methodBudgets[method]
+	if !ok {
+		b = newBudget(cfg)
+		l.methodBudgets[method] = b
+	}
+	return b
+}
+
+func evaluate(b *budget, n float64) RateLimitDecision {
+	if b.allowN(n) {
+		return RateLimitDecision{Allowed: true}
+	}
+	return RateLimitDecision{Allowed: false, RetryAfter: b.retryAfter(n)}
+}
+
+// AllowBatch resolves the ip/key/method budgets methodCounts touches, confirms every one of them currently has
+// capacity (without debiting anything), and only then debits them all - see the RateLimiter interface doc for why a
+// naive check-and-debit-per-method loop is unsafe for batches.
+func (l *InMemoryRateLimiter) AllowBatch(_ context.Context, ip string, apiKey string, methodCounts map[string]int) RateLimitDecision {
+	total := 0
+	for _, count := range methodCounts {
+		total += count
+	}
+	n := float64(total)
+	if n <= 0 {
+		n = 1
+	}
+
+	var ipBudget *budget
+	if l.cfg.IP.Rate > 0 {
+		ipBudget = l.ipBudgets.getOrCreate(ip, l.cfg.IP)
+	}
+	var keyBudget *budget
+	if apiKey != "" && l.cfg.Key.Rate > 0 {
+		keyBudget = l.keyBudgets.getOrCreate(apiKey, l.cfg.Key)
+	}
+	methodBudgets := make(map[string]*budget, len(methodCounts))
+	for method := range methodCounts {
+		if method == "" {
+			continue
+		}
+		if cfg, ok := l.cfg.Method[method]; ok && cfg.Rate > 0 {
+			methodBudgets[method] = l.methodBudget(method, cfg)
+		}
+	}
+
+	if ipBudget != nil && !ipBudget.wouldAllowN(n) {
+		return RateLimitDecision{Allowed: false, RetryAfter: ipBudget.retryAfter(n)}
+	}
+	if keyBudget != nil && !keyBudget.wouldAllowN(n) {
+		return RateLimitDecision{Allowed: false, RetryAfter: keyBudget.retryAfter(n)}
+	}
+	for method, count := range methodCounts {
+		b, ok := methodBudgets[method]
+		if !ok {
+			continue
+		}
+		if mn := float64(count); !b.wouldAllowN(mn) {
+			return RateLimitDecision{Allowed: false, RetryAfter: b.retryAfter(mn)}
+		}
+	}
+
+	// Every dimension has capacity as of the checks above; debit them all together.
+	if ipBudget != nil {
+		ipBudget.allowN(n)
+	}
+	if keyBudget != nil {
+		keyBudget.allowN(n)
+	}
+	for method, count := range methodCounts {
+		if b, ok := methodBudgets[method]; ok {
+			b.allowN(float64(count))
+		}
+	}
+
+	return RateLimitDecision{Allowed: true}
+}
+
+// RedisClient is the subset of a Redis client RedisRateLimiter needs, so it doesn't have to depend on a specific
+// client library. Adapt e.g. *redis.Client from github.com/redis/go-redis to this interface at wiring time.
+type RedisClient interface {
+	IncrBy(ctx context.Context, key string, n int64) (int64, error)
+	Expire(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// RedisRateLimiter is a horizontally-scalable RateLimiter backed by Redis. Each dimension (ip/key/method) is
+// approximated as a fixed one-second counter window rather than a true token bucket, which is a reasonable
+// approximation for this use case and keeps the Redis-side logic to a single INCRBY/EXPIRE pair per check.
+type RedisRateLimiter struct {
+	client RedisClient
+	cfg    RateLimiterConfig
+}
+
+func NewRedisRateLimiter(client RedisClient, cfg RateLimiterConfig) *RedisRateLimiter {
+	return &RedisRateLimiter{client: client, cfg: cfg}
+}
+
+func (l *RedisRateLimiter) Allow(ctx context.Context, ip string, apiKey string, method string, cost int) RateLimitDecision {
+	if dec := l.checkWindow(ctx, "ip", ip, l.cfg.IP, cost); !dec.Allowed {
+		return dec
+	}
+	if apiKey != "" {
+		if dec := l.checkWindow(ctx, "key", apiKey, l.cfg.Key, cost); !dec.Allowed {
+			return dec
+		}
+	}
+	if method != "" {
+		if methodCfg, ok := l.cfg.Method[method]; ok {
+			if dec := l.checkWindow(ctx, "method", method, methodCfg, cost); !dec.Allowed {
+				return dec
+			}
+		}
+	}
+	return RateLimitDecision{Allowed: true}
+}
+
+// AllowBatch sums methodCounts into a single cost for the ip/key dimensions (as Allow does per-entry), then checks
+// each distinct method against its own window at its actual count. Unlike InMemoryRateLimiter.AllowBatch this isn't
+// a true check-then-commit: checkWindow's INCRBY already debits on the call that discovers the rejection, the same
+// approximation Allow makes for a single request's ip/key/method dimensions. A batch that blows a later method's
+// budget after an earlier one already passed therefore still costs that earlier method's window some of its budget;
+// avoiding that fully would need a Lua script evaluated server-side, which RedisClient doesn't expose.
+func (l *RedisRateLimiter) AllowBatch(ctx context.Context, ip string, apiKey string, methodCounts map[string]int) RateLimitDecision {
+	total := 0
+	for _, count := range methodCounts {
+		total += count
+	}
+
+	if dec := l.checkWindow(ctx, "ip", ip, l.cfg.IP, total); !dec.Allowed {
+		return dec
+	}
+	if apiKey != "" {
+		if dec := l.checkWindow(ctx, "key", apiKey, l.cfg.Key, total); !dec.Allowed {
+			return dec
+		}
+	}
+	for method, count := range methodCounts {
+		if method == "" {
+			continue
+		}
+		if methodCfg, ok := l.cfg.Method[method]; ok {
+			if dec := l.checkWindow(ctx, "method", method, methodCfg, count); !dec.Allowed {
+				return dec
+			}
+		}
+	}
+	return RateLimitDecision{Allowed: true}
+}
+
+func (l *RedisRateLimiter) checkWindow(ctx context.Context, dimension, key string, cfg BucketConfig, cost int) RateLimitDecision {
+	if cfg.Rate <= 0 {
+		return RateLimitDecision{Allowed: true}
+	}
+
+	limit := cfg.Burst + cfg.BonusBurst
+	if limit <= 0 {
+		limit = int(cfg.Rate)
+	}
+
+	windowKey := fmt.Sprintf("ratelimit:%s:%s:%d", dimension, key, time.Now().Unix())
+	count, err := l.client.IncrBy(ctx, windowKey, int64(cost))
+	if err != nil {
+		// Fail open: a Redis outage shouldn't take the RPC endpoint down with it.
+		return RateLimitDecision{Allowed: true}
+	}
+	if count == int64(cost) {
+		_, _ = l.client.Expire(ctx, windowKey, time.Second)
+	}
+	if count > int64(limit) {
+		return RateLimitDecision{Allowed: false, RetryAfter: time.Second}
+	}
+	return RateLimitDecision{Allowed: true}
+}
+
+// extractApiKey pulls an optional API key from the Authorization header ("Bearer <key>") or an "api_key"/"key"
+// query param, in that order of preference, truncated to maxApiKeyLength so a client can't inflate the rate
+// limiter's per-key memory footprint with an oversized header/param.
+func extractApiKey(req *http.Request) string {
+	var key string
+	switch {
+	case req.Header.Get("Authorization") != "":
+		auth := req.Header.Get("Authorization")
+		if trimmed := strings.TrimPrefix(auth, "Bearer "); trimmed != auth {
+			key = trimmed
+		} else {
+			key = auth
+		}
+	case req.URL.Query().Get("api_key") != "":
+		key = req.URL.Query().Get("api_key")
+	default:
+		key = req.URL.Query().Get("key")
+	}
+
+	if len(key) > maxApiKeyLength {
+		key = key[:maxApiKeyLength]
+	}
+	return key
+}
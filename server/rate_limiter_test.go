@@ -0,0 +1,74 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllowsUpToBurstThenBlocks(t *testing.T) {
+	tb := newTokenBucket(1, 3)
+
+	for i := 0; i < 3; i++ {
+		if !tb.Allow() {
+			t.Fatalf("call %d: expected burst capacity to allow the request", i)
+		}
+	}
+	if tb.Allow() {
+		t.Fatal("expected bucket to be empty after exhausting its burst")
+	}
+}
+
+func TestTokenBucketRefillsOverTime(t *testing.T) {
+	tb := newTokenBucket(2, 1) // 2 tokens/sec, burst of 1
+
+	if !tb.Allow() {
+		t.Fatal("expected the initial token to be available")
+	}
+	if tb.Allow() {
+		t.Fatal("expected the bucket to be empty immediately after the first Allow")
+	}
+
+	// Simulate half a second elapsing (1 token at a 2/sec rate) without a real sleep.
+	tb.mu.Lock()
+	tb.lastRefill = tb.lastRefill.Add(-500 * time.Millisecond)
+	tb.mu.Unlock()
+
+	if !tb.Allow() {
+		t.Fatal("expected a token to have refilled after simulating 500ms at a 2 token/sec rate")
+	}
+}
+
+func TestTokenBucketRetryAfterReflectsDeficit(t *testing.T) {
+	tb := newTokenBucket(2, 1)
+	tb.Allow() // drain the only token
+
+	retry := tb.retryAfter(1)
+	if retry <= 0 || retry > time.Second {
+		t.Fatalf("retryAfter(1) = %v, want a positive duration close to 500ms at a 2 token/sec rate", retry)
+	}
+}
+
+func TestBudgetFallsBackToBonusBucketOncePrimaryIsExhausted(t *testing.T) {
+	b := newBudget(BucketConfig{Rate: 1, Burst: 1, BonusRate: 0.1, BonusBurst: 1})
+
+	if !b.allowN(1) {
+		t.Fatal("expected the primary bucket to allow the first request")
+	}
+	if !b.allowN(1) {
+		t.Fatal("expected the bonus bucket to allow a second request once the primary is exhausted")
+	}
+	if b.allowN(1) {
+		t.Fatal("expected both primary and bonus buckets to be exhausted after two requests")
+	}
+}
+
+func TestBudgetWithoutBonusBucketBlocksImmediatelyOnExhaustion(t *testing.T) {
+	b := newBudget(BucketConfig{Rate: 1, Burst: 1})
+
+	if !b.allowN(1) {
+		t.Fatal("expected the primary bucket to allow the first request")
+	}
+	if b.allowN(1) {
+		t.Fatal("expected no bonus bucket to back up the primary once it's exhausted")
+	}
+}
@@ -4,23 +4,32 @@ Request represents an incoming client request
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/google/uuid"
 )
 
+// batchWorkerPoolSize bounds how many entries of a JSON-RPC batch request are dispatched concurrently.
+const batchWorkerPoolSize = 10
+
 // MetaMask keeps re-sending tx, bombarding the system with eth_sendRawTransaction calls. If this happens, we prevent
 // the tx from being forwarded to the TxManager, and force MetaMask to return an error (using eth_getTransactionCount).
+var blacklistedRawTxMu sync.Mutex
 var blacklistedRawTx = make(map[string]time.Time) // key is the rawTxHex, value is time added
+
+var mmNonceMu sync.Mutex
 var mmBlacklistedAccountAndNonce = make(map[string]*mmNonceHelper)
 
 type mmNonceHelper struct {
@@ -33,18 +42,32 @@ type RpcRequest struct {
 	respw *http.ResponseWriter
 	req   *http.Request
 
-	uid             string
-	timeStarted     time.Time
-	defaultProxyUrl string
-	txManagerUrl    string
+	uid         string
+	timeStarted time.Time
+
+	// ctx overrides the context used for backend calls (proxying, nonce lookups). Set by ensureTraceID in process(),
+	// and by dispatchIsolated for requests that don't originate from req (batch entries, non-subscription WebSocket
+	// methods via HandleSingle); nil means "use req.Context()".
+	ctx context.Context
+
+	// primaryBackends serves normal reads/writes (mempool transactions, eth_call, etc); protectedBackends is used
+	// for eth_sendRawTransaction calls that need frontrunning protection (e.g. a Flashbots TxManager).
+	primaryBackends   *BackendGroup
+	protectedBackends *BackendGroup
+	cache             *ResponseCache // nil disables response caching
+	limiter           RateLimiter    // nil disables rate limiting
+	metrics           *Metrics       // nil disables metrics recording
 
 	// extracted during request lifecycle:
 	body     []byte
 	jsonReq  *JsonRpcRequest
 	ip       string
+	apiKey   string
 	rawTxHex string
 	tx       *types.Transaction
 	txFrom   string
+	servedBy string // "<group>/<backend>" that ultimately served this request, for the X-Served-By header
+	outcome  string // coarse result of process(), for the requests_total metric (e.g. "dispatched", "batch", "blocked", "rate_limited")
 
 	// response flags
 	respHeaderContentTypeWritten bool
@@ -52,32 +75,25 @@ type RpcRequest struct {
 	respBodyWritten              bool
 }
 
-func NewRpcRequest(respw *http.ResponseWriter, req *http.Request, proxyUrl string, txManagerUrl string) *RpcRequest {
+func NewRpcRequest(respw *http.ResponseWriter, req *http.Request, primaryBackends *BackendGroup, protectedBackends *BackendGroup, cache *ResponseCache, limiter RateLimiter, metrics *Metrics) *RpcRequest {
 	return &RpcRequest{
-		respw:           respw,
-		req:             req,
-		uid:             uuid.New().String(),
-		timeStarted:     time.Now(),
-		defaultProxyUrl: proxyUrl,
-		txManagerUrl:    txManagerUrl,
+		respw:             respw,
+		req:               req,
+		uid:               uuid.New().String(),
+		timeStarted:       time.Now(),
+		primaryBackends:   primaryBackends,
+		protectedBackends: protectedBackends,
+		cache:             cache,
+		limiter:           limiter,
+		metrics:           metrics,
 	}
 }
 
-func (r *RpcRequest) log(format string, v ...interface{}) {
-	prefix := fmt.Sprintf("[%s] ", r.uid)
-	log.Printf(prefix+format, v...)
-}
-
-func (r *RpcRequest) logError(format string, v ...interface{}) {
-	prefix := fmt.Sprintf("[%s] ERROR: ", r.uid)
-	log.Printf(prefix+format, v...)
-}
-
 func (r *RpcRequest) writeHeaderStatus(statusCode int) {
 	if r.respHeaderStatusCodeWritten {
 		return
 	}
-	(*r.respw).WriteHeader(http.StatusUnauthorized)
+	(*r.respw).WriteHeader(statusCode)
 	r.respHeaderStatusCodeWritten = true
 }
 
@@ -93,13 +109,56 @@ func (r *RpcRequest) writeHeaderContentTypeJson() {
 	r.writeHeaderContentType("application/json")
 }
 
+// context returns the context backend calls should run under: r.ctx if set (by ensureTraceID, or for
+// WebSocket/batch-isolated requests), otherwise r.req's own context.
+func (r *RpcRequest) context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	if r.req != nil {
+		return r.req.Context()
+	}
+	return context.Background()
+}
+
+// ensureTraceID attaches a trace ID to r.ctx (deriving from r.req's context if r.ctx isn't already set), so every
+// subsequent r.context() call - and therefore every backend call and log line - carries the same trace ID.
+func (r *RpcRequest) ensureTraceID() {
+	r.ctx = withTraceID(r.context())
+}
+
+// writeHeaderServedBy sets X-Served-By to the "<group>/<backend>" that handled this request, so callers can
+// correlate responses with the backend that produced them.
+func (r *RpcRequest) writeHeaderServedBy() {
+	if r.respHeaderStatusCodeWritten || r.servedBy == "" {
+		return
+	}
+	(*r.respw).Header().Set("X-Served-By", r.servedBy)
+}
+
+// writeHeaderCacheStatus sets X-Cache to "HIT" or "MISS" to indicate whether the response came from the
+// ResponseCache.
+func (r *RpcRequest) writeHeaderCacheStatus(status string) {
+	if r.respHeaderStatusCodeWritten {
+		return
+	}
+	(*r.respw).Header().Set("X-Cache", status)
+}
+
 func (r *RpcRequest) process() {
 	var err error
 
-	// At end of request, log the time it needed
+	r.ensureTraceID()
+
+	// At end of request, log the time it needed and record the requests_total metric
 	defer func() {
 		timeRequestNeeded := time.Since(r.timeStarted)
-		r.log("request took %.6f sec", timeRequestNeeded.Seconds())
+		r.logDuration(timeRequestNeeded, "request took %.6f sec", timeRequestNeeded.Seconds())
+		method := ""
+		if r.jsonReq != nil {
+			method = r.jsonReq.Method
+		}
+		r.metrics.RecordRequest(method, r.outcome)
 	}()
 
 	r.ip = GetIP(r.req)
@@ -107,6 +166,7 @@ func (r *RpcRequest) process() {
 
 	if IsBlacklisted(r.ip) {
 		r.log("Blocked: IP=%s", r.ip)
+		r.outcome = "blocked"
 		r.writeHeaderStatus(http.StatusUnauthorized)
 		return
 	}
@@ -115,8 +175,8 @@ func (r *RpcRequest) process() {
 	// e.g. https://rpc.flashbots.net?url=http://RPC-ENDPOINT.COM
 	customProxyUrl, ok := r.req.URL.Query()["url"]
 	if ok && len(customProxyUrl[0]) > 1 {
-		r.defaultProxyUrl = customProxyUrl[0]
-		r.log("Using custom url: %s", r.defaultProxyUrl)
+		r.primaryBackends = NewSingleBackendGroup("custom", customProxyUrl[0])
+		r.log("Using custom url: %s", customProxyUrl[0])
 	}
 
 	// Decode request JSON RPC
@@ -124,13 +184,34 @@ func (r *RpcRequest) process() {
 	r.body, err = ioutil.ReadAll(r.req.Body)
 	if err != nil {
 		r.logError("failed to read request body: %v", err)
+		r.outcome = "bad_request"
 		r.writeHeaderStatus(http.StatusBadRequest)
 		return
 	}
 
+	r.apiKey = extractApiKey(r.req)
+
+	// A JSON-RPC batch request is a top-level JSON array rather than an object (JSON-RPC 2.0 spec). Each distinct
+	// method in the batch is checked against its own per-method budget at its actual entry count, so e.g. a batch of
+	// eth_sendRawTransaction calls still debits that method's budget rather than bypassing it. checkRateLimitBatch
+	// checks every dimension (ip, key and each method present) before debiting any of them, so a batch mixing a
+	// cheap method with one that blows its own budget is rejected without draining ip/key tokens for work that never
+	// runs.
+	if isBatchRequest(r.body) {
+		if dec := r.checkRateLimitBatch(batchMethodCounts(r.body)); !dec.Allowed {
+			r.outcome = "rate_limited"
+			r.writeRateLimitExceeded(dec)
+			return
+		}
+		r.outcome = "batch"
+		r.processBatch()
+		return
+	}
+
 	// Parse JSON RPC
 	if err = json.Unmarshal(r.body, &r.jsonReq); err != nil {
 		r.logError("failed to parse JSON RPC request: %v", err)
+		r.outcome = "bad_request"
 		r.writeHeaderStatus(http.StatusBadRequest)
 		return
 	}
@@ -140,6 +221,58 @@ func (r *RpcRequest) process() {
 	// 	r.log("rpcreq method: %s args: %s", r.jsonReq.Method, r.jsonReq.Params)
 	// }
 
+	if dec := r.checkRateLimit(r.jsonReq.Method, 1); !dec.Allowed {
+		r.outcome = "rate_limited"
+		r.writeRateLimitExceeded(dec)
+		return
+	}
+
+	r.outcome = "dispatched"
+	r.dispatch()
+}
+
+// checkRateLimit consults r.limiter, if configured, for cost tokens against r.ip/r.apiKey/method. A nil limiter (no
+// rate limiting configured) always allows.
+func (r *RpcRequest) checkRateLimit(method string, cost int) RateLimitDecision {
+	if r.limiter == nil {
+		return RateLimitDecision{Allowed: true}
+	}
+	return r.limiter.Allow(r.context(), r.ip, r.apiKey, method, cost)
+}
+
+// checkRateLimitBatch consults r.limiter, if configured, for every method in methodCounts against r.ip/r.apiKey,
+// atomically: see RateLimiter.AllowBatch for why a per-method loop over checkRateLimit is unsafe for batches.
+func (r *RpcRequest) checkRateLimitBatch(methodCounts map[string]int) RateLimitDecision {
+	if r.limiter == nil {
+		return RateLimitDecision{Allowed: true}
+	}
+	return r.limiter.AllowBatch(r.context(), r.ip, r.apiKey, methodCounts)
+}
+
+// batchMethodCounts tallies how many entries of a JSON-RPC batch request body call each method, for per-method
+// rate-limit cost purposes. Entries that don't parse (or lack a method) are tallied under "", so they still count
+// against the IP/key budgets even though no per-method budget applies to them. A malformed batch (not a JSON array)
+// is treated as a single unit of cost under ""; processBatch will reject it properly afterwards.
+func batchMethodCounts(body []byte) map[string]int {
+	var rawEntries []json.RawMessage
+	if err := json.Unmarshal(body, &rawEntries); err != nil || len(rawEntries) == 0 {
+		return map[string]int{"": 1}
+	}
+
+	counts := make(map[string]int)
+	for _, rawEntry := range rawEntries {
+		var entry struct {
+			Method string `json:"method"`
+		}
+		_ = json.Unmarshal(rawEntry, &entry)
+		counts[entry.Method]++
+	}
+	return counts
+}
+
+// dispatch runs the method-specific handling for r.jsonReq and writes the result to r.respw. It is the shared
+// entrypoint used both for plain single requests and for each entry of a batch request.
+func (r *RpcRequest) dispatch() {
 	if r.jsonReq.Method == "eth_sendRawTransaction" {
 		r.handle_sendRawTransaction()
 
@@ -154,11 +287,28 @@ func (r *RpcRequest) process() {
 			return
 		}
 
+		// Serve from the response cache if possible
+		var lookup cacheLookup
+		if r.cache != nil {
+			lookup = r.cache.prepare(r.jsonReq, r.primaryBackends)
+			if cached, hit := r.cache.Get(lookup); hit {
+				cached.Id = r.jsonReq.Id
+				r.writeHeaderCacheStatus("HIT")
+				r._writeRpcResponse(cached)
+				r.log("Cache hit: %s", r.jsonReq.Method)
+				return
+			}
+		}
+
 		// Proxy the request to a node
-		readJsonRpcSuccess, proxyHttpStatus, jsonResp := r.proxyRequestRead(r.defaultProxyUrl)
+		readJsonRpcSuccess, proxyHttpStatus, jsonResp := r.proxyRequestRead(r.primaryBackends, false)
 
 		// Write the response to user
 		if readJsonRpcSuccess {
+			if r.cache != nil {
+				r.cache.Put(lookup, jsonResp)
+			}
+			r.writeHeaderCacheStatus("MISS")
 			r.writeHeaderStatus(proxyHttpStatus)
 			r._writeRpcResponse(jsonResp)
 			r.log("Proxy to node successful: %s", r.jsonReq.Method)
@@ -169,6 +319,153 @@ func (r *RpcRequest) process() {
 	}
 }
 
+// isBatchRequest returns true if body is a JSON-RPC batch request, i.e. a top-level JSON array.
+func isBatchRequest(body []byte) bool {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// processBatch handles a JSON-RPC batch request: each entry is dispatched through the same per-method logic as a
+// single request (including eth_sendRawTransaction routing, intercepts and OFAC checks), bounded to
+// batchWorkerPoolSize concurrent entries, and the (ordered) results are written back as a single JSON array.
+func (r *RpcRequest) processBatch() {
+	var rawEntries []json.RawMessage
+	if err := json.Unmarshal(r.body, &rawEntries); err != nil {
+		r.logError("failed to parse JSON RPC batch request: %v", err)
+		r.writeHeaderStatus(http.StatusBadRequest)
+		return
+	}
+
+	// Per spec, "rpc call with an empty Array" returns a single Request error, not an empty array.
+	if len(rawEntries) == 0 {
+		r.writeHeaderContentTypeJson()
+		r.writeHeaderStatus(http.StatusOK)
+		res := JsonRpcResponse{
+			Version: "2.0",
+			Error: &JsonRpcError{
+				Code:    -32600,
+				Message: "invalid request: empty batch",
+			},
+		}
+		_ = json.NewEncoder(*r.respw).Encode(&res)
+		r.respBodyWritten = true
+		return
+	}
+
+	r.log("JSON-RPC batch request with %d entries, ip: %s", len(rawEntries), r.ip)
+
+	type batchResult struct {
+		resp           *JsonRpcResponse
+		isNotification bool
+	}
+
+	results := make([]batchResult, len(rawEntries))
+	sem := make(chan struct{}, batchWorkerPoolSize)
+	var wg sync.WaitGroup
+
+	for i, rawEntry := range rawEntries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, rawEntry json.RawMessage) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = r.processBatchEntry(rawEntry)
+		}(i, rawEntry)
+	}
+	wg.Wait()
+
+	// Reassemble in original order, dropping notifications (requests without an "id") as required by the spec.
+	responses := make([]*JsonRpcResponse, 0, len(results))
+	for _, res := range results {
+		if res.isNotification {
+			continue
+		}
+		responses = append(responses, res.resp)
+	}
+
+	r.writeHeaderContentTypeJson()
+	r.writeHeaderStatus(http.StatusOK)
+	if err := json.NewEncoder(*r.respw).Encode(responses); err != nil {
+		r.logError("failed writing batch rpc response: %v", err)
+		r.writeHeaderStatus(http.StatusInternalServerError)
+	}
+	r.respBodyWritten = true
+}
+
+// processBatchEntry dispatches a single entry of a batch request in isolation: a lightweight clone of r is used so
+// that per-request state (jsonReq, rawTxHex, tx, txFrom, response flags) doesn't race across concurrently-running
+// entries, while the response is captured instead of being written to the real http.ResponseWriter directly.
+func (r *RpcRequest) processBatchEntry(rawEntry json.RawMessage) (result struct {
+	resp           *JsonRpcResponse
+	isNotification bool
+}) {
+	var idProbe struct {
+		Id interface{} `json:"id"`
+	}
+	hasId := json.Unmarshal(rawEntry, &idProbe) == nil && idProbe.Id != nil
+	result.isNotification = !hasId
+
+	var entryJsonReq JsonRpcRequest
+	if err := json.Unmarshal(rawEntry, &entryJsonReq); err != nil {
+		r.logError("failed to parse JSON RPC batch entry: %v", err)
+		result.resp = &JsonRpcResponse{
+			Version: "2.0",
+			Error:   &JsonRpcError{Code: -32600, Message: "invalid request"},
+		}
+		return
+	}
+
+	r.log("JSON-RPC batch entry method: %s ip: %s", entryJsonReq.Method, r.ip)
+	resp, _ := r.dispatchIsolated(r.context(), &entryJsonReq, rawEntry)
+
+	if result.isNotification {
+		return
+	}
+
+	result.resp = resp
+	return
+}
+
+// dispatchIsolated runs dispatch for jsonReq/body against a private in-memory response writer instead of r's real
+// one, so the result can be returned as a value (and per-request state like rawTxHex/tx/txFrom doesn't race with
+// concurrently-running siblings). It shares r's backends, cache and identity (uid, ip), but runs under ctx rather
+// than r's own request context. Used by processBatchEntry and HandleSingle.
+func (r *RpcRequest) dispatchIsolated(ctx context.Context, jsonReq *JsonRpcRequest, body []byte) (resp *JsonRpcResponse, httpStatus int) {
+	buf := newBufferedResponseWriter()
+	var bufWriter http.ResponseWriter = buf
+	item := &RpcRequest{
+		respw:             &bufWriter,
+		req:               r.req,
+		ctx:               withTraceID(ctx),
+		uid:               r.uid,
+		timeStarted:       r.timeStarted,
+		primaryBackends:   r.primaryBackends,
+		protectedBackends: r.protectedBackends,
+		cache:             r.cache,
+		limiter:           r.limiter,
+		metrics:           r.metrics,
+		body:              body,
+		jsonReq:           jsonReq,
+		ip:                r.ip,
+		apiKey:            r.apiKey,
+	}
+	item.dispatch()
+	return buf.jsonRpcResponse(jsonReq.Id), buf.statusCode
+}
+
+// HandleSingle dispatches a single already-parsed JSON-RPC request through the same per-method pipeline used by the
+// HTTP handler (eth_sendRawTransaction routing, OFAC checks, intercepts, caching), without touching r's own
+// transport. It is the transport-agnostic entrypoint other transports (e.g. the WebSocket handler) call so
+// non-subscription methods behave identically regardless of how they arrived.
+func (r *RpcRequest) HandleSingle(ctx context.Context, jsonReq *JsonRpcRequest) (*JsonRpcResponse, error) {
+	body, err := json.Marshal(jsonReq)
+	if err != nil {
+		return nil, err
+	}
+	resp, _ := r.dispatchIsolated(ctx, jsonReq, body)
+	return resp, nil
+}
+
 func (r *RpcRequest) handle_sendRawTransaction() {
 	var err error
 
@@ -188,8 +485,12 @@ func (r *RpcRequest) handle_sendRawTransaction() {
 
 	r.log("rawTx: %s", r.rawTxHex)
 
-	if _, isBlacklistedTx := blacklistedRawTx[r.rawTxHex]; isBlacklistedTx {
+	blacklistedRawTxMu.Lock()
+	_, isBlacklistedTx := blacklistedRawTx[r.rawTxHex]
+	blacklistedRawTxMu.Unlock()
+	if isBlacklistedTx {
 		r.log("rawTx blocked because bundle failed too many times")
+		r.metrics.RecordBlacklistHit()
 		r.writeRpcError("rawTx blocked because bundle failed too many times")
 		return
 	}
@@ -210,6 +511,7 @@ func (r *RpcRequest) handle_sendRawTransaction() {
 
 	if isOnOFACList(r.txFrom) {
 		r.log("BLOCKED TX FROM OFAC SANCTIONED ADDRESS")
+		r.metrics.RecordOFACBlock()
 		r.writeHeaderStatus(http.StatusUnauthorized)
 		return
 	}
@@ -222,14 +524,15 @@ func (r *RpcRequest) handle_sendRawTransaction() {
 	}
 
 	target := "mempool"
-	url := r.defaultProxyUrl
+	backends := r.primaryBackends
 	if needsProtection {
 		target = "Flashbots"
-		url = r.txManagerUrl
+		backends = r.protectedBackends
 	}
+	r.metrics.RecordSendRawTxRoute(target)
 
 	// Proxy now!
-	readJsonRpcSuccess, proxyHttpStatus, jsonResp := r.proxyRequestRead(url)
+	readJsonRpcSuccess, proxyHttpStatus, jsonResp := r.proxyRequestRead(backends, true)
 
 	// Log after proxying
 	if !readJsonRpcSuccess {
@@ -256,35 +559,33 @@ func (r *RpcRequest) handle_sendRawTransaction() {
 	}
 }
 
-// Proxies the incoming request to the target URL, and tries to parse JSON-RPC response (and check for specific)
-func (r *RpcRequest) proxyRequestRead(proxyUrl string) (readJsonRpsResponseSuccess bool, httpStatusCode int, jsonResp *JsonRpcResponse) {
+// proxyRequestRead forwards r.body to a healthy backend picked from backends, and tries to parse the JSON-RPC
+// response. The name of the backend that served the request is recorded on r.servedBy for the X-Served-By header.
+// isWrite must be true for eth_sendRawTransaction (see BackendGroup.Forward) despite this method's name: it predates
+// that distinction and proxies both reads and the one write path the same way otherwise.
+func (r *RpcRequest) proxyRequestRead(backends *BackendGroup, isWrite bool) (readJsonRpsResponseSuccess bool, httpStatusCode int, jsonResp *JsonRpcResponse) {
 	timeProxyStart := time.Now() // for measuring execution time
-	r.log("proxyRequest to: %s", proxyUrl)
+	r.log("proxyRequest to backend group: %s", backends.Name)
 
 	// Proxy request
-	proxyResp, err := ProxyRequest(proxyUrl, r.body)
+	proxyRespBody, statusCode, servedBy, err := backends.Forward(r.context(), r.body, isWrite)
 
 	// Afterwards, check time and result
 	timeProxyNeeded := time.Since(timeProxyStart)
-	r.log("proxy response %d after %.6f: %v", proxyResp.StatusCode, timeProxyNeeded.Seconds(), proxyResp)
+	r.logDuration(timeProxyNeeded, "proxy response %d after %.6f: served by %s", statusCode, timeProxyNeeded.Seconds(), servedBy)
+	r.metrics.ObserveProxyDuration(backends.Name, timeProxyNeeded)
 	if err != nil {
 		r.logError("failed to make proxy request: %v", err)
-		return false, proxyResp.StatusCode, jsonResp
-	}
-
-	// Read body
-	defer proxyResp.Body.Close()
-	proxyRespBody, err := ioutil.ReadAll(proxyResp.Body)
-	if err != nil {
-		r.logError("failed to decode proxy request body: %v", err)
-		return false, proxyResp.StatusCode, jsonResp
+		return false, statusCode, jsonResp
 	}
+	r.servedBy = servedBy
+	r.writeHeaderServedBy()
 
 	// Unmarshall JSON-RPC response and check for error inside
 	jsonRpcResp := new(JsonRpcResponse)
 	if err := json.Unmarshal(proxyRespBody, jsonRpcResp); err != nil {
 		r.logError("failed decoding proxy json-rpc response: %v", err)
-		return false, proxyResp.StatusCode, jsonResp
+		return false, statusCode, jsonResp
 	}
 
 	// If JSON-RPC had an error response, parse but still pass back to user
@@ -292,15 +593,16 @@ func (r *RpcRequest) proxyRequestRead(proxyUrl string) (readJsonRpsResponseSucce
 		r.handleProxyError(jsonRpcResp.Error)
 	}
 
-	return true, proxyResp.StatusCode, jsonRpcResp
+	return true, statusCode, jsonRpcResp
 }
 
 func (r *RpcRequest) handleProxyError(rpcError *JsonRpcError) {
 	r.log("proxy response json-rpc error: %s", rpcError.Error())
 
 	if rpcError.Message == "Bundle submitted has already failed too many times" {
+		blacklistedRawTxMu.Lock()
 		blacklistedRawTx[r.rawTxHex] = time.Now()
-		r.log("rawTx added to blocklist. entries: %d", len(blacklistedRawTx))
+		numBlacklisted := len(blacklistedRawTx)
 
 		// Cleanup old rawTx blacklist entries
 		for key, entry := range blacklistedRawTx {
@@ -308,17 +610,22 @@ func (r *RpcRequest) handleProxyError(rpcError *JsonRpcError) {
 				delete(blacklistedRawTx, key)
 			}
 		}
+		blacklistedRawTxMu.Unlock()
+		r.log("rawTx added to blocklist. entries: %d", numBlacklisted)
 
 		// To prepare for MM retrying the transactions, we get the txCount and then return it +1 for next four tries
-		nonce, err := eth_getTransactionCount(r.defaultProxyUrl, r.txFrom)
+		nonce, err := fetchTransactionCount(r.context(), r.primaryBackends, r.txFrom)
 		if err != nil {
 			r.logError("failed getting nonce: %s", err)
 			return
 		}
 		// fmt.Println("NONCE", nonce, "for", r.txFrom)
+		mmNonceMu.Lock()
 		mmBlacklistedAccountAndNonce[strings.ToLower(r.txFrom)] = &mmNonceHelper{
 			Nonce: nonce,
 		}
+		mmNonceMu.Unlock()
+		r.metrics.RecordMMNonceActivation()
 	}
 }
 
@@ -348,6 +655,37 @@ func (r *RpcRequest) doesTxNeedFrontrunningProtection(tx *types.Transaction) (bo
 	}
 }
 
+// writeRateLimitExceeded responds with JSON-RPC error -32005 ("limit exceeded"), HTTP 429, and a Retry-After header
+// telling the client how long to back off.
+func (r *RpcRequest) writeRateLimitExceeded(dec RateLimitDecision) {
+	r.log("rate limit exceeded: ip=%s retry-after=%s", r.ip, dec.RetryAfter)
+
+	retryAfterSeconds := int(dec.RetryAfter.Seconds())
+	if retryAfterSeconds < 1 {
+		retryAfterSeconds = 1
+	}
+	(*r.respw).Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+
+	var id interface{}
+	if r.jsonReq != nil {
+		id = r.jsonReq.Id
+	}
+	res := JsonRpcResponse{
+		Id:      id,
+		Version: "2.0",
+		Error: &JsonRpcError{
+			Code:    -32005,
+			Message: "limit exceeded",
+		},
+	}
+	r.writeHeaderContentTypeJson()
+	r.writeHeaderStatus(http.StatusTooManyRequests)
+	if err := json.NewEncoder(*r.respw).Encode(&res); err != nil {
+		r.logError("failed writing rate limit response: %v", err)
+	}
+	r.respBodyWritten = true
+}
+
 func (r *RpcRequest) writeRpcError(msg string) {
 	res := JsonRpcResponse{
 		Id:      r.jsonReq.Id,
@@ -385,3 +723,50 @@ func (r *RpcRequest) _writeRpcResponse(res *JsonRpcResponse) {
 
 	r.respBodyWritten = true
 }
+
+// bufferedResponseWriter is a minimal in-memory http.ResponseWriter used to capture the result of dispatching a
+// single batch entry, so that it can be folded into the outer JSON array instead of being written to the client
+// directly.
+type bufferedResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{header: make(http.Header), statusCode: http.StatusOK}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+}
+
+// jsonRpcResponse turns the captured output into a JsonRpcResponse for inclusion in a batch response array. If
+// dispatch only set a status code without writing a JSON-RPC body (e.g. a malformed sub-request), a JSON-RPC error
+// carrying that status is synthesized instead, so every non-notification entry in the batch gets a response.
+func (w *bufferedResponseWriter) jsonRpcResponse(id interface{}) *JsonRpcResponse {
+	if w.body.Len() > 0 {
+		res := new(JsonRpcResponse)
+		if err := json.Unmarshal(w.body.Bytes(), res); err == nil {
+			res.Id = id
+			return res
+		}
+	}
+
+	return &JsonRpcResponse{
+		Id:      id,
+		Version: "2.0",
+		Error: &JsonRpcError{
+			Code:    -32603,
+			Message: fmt.Sprintf("internal error (http status %d)", w.statusCode),
+		},
+	}
+}
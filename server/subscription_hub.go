@@ -0,0 +1,284 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// subscriptionHub maintains a single upstream WebSocket connection per distinct (topic, filter) pair and fans its
+// notifications out to every local client subscribed to that pair, regardless of how many clients asked for it.
+type subscriptionHub struct {
+	backends *BackendGroup
+
+	mu      sync.Mutex
+	streams map[string]*upstreamStream // key -> shared upstream subscription
+	subs    map[string]*localSub       // local subscription id -> owning stream
+}
+
+// localSub is one client's view of an upstreamStream: its own subscription id (handed back from eth_subscribe) and
+// the enqueue func used to deliver notifications to that client.
+type localSub struct {
+	streamKey string
+	enqueue   func([]byte)
+}
+
+// upstreamStream is a single eth_subscribe connection to a backend, shared by every local subscriber asking for the
+// same topic/filter.
+type upstreamStream struct {
+	key    string
+	topic  subscriptionTopic
+	filter interface{} // the original eth_subscribe filter arg, kept so redial can recreate this exact subscription
+	conn   *websocket.Conn
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	subs map[string]bool // local subscription ids currently referencing this stream
+}
+
+func newSubscriptionHub(backends *BackendGroup) *subscriptionHub {
+	return &subscriptionHub{
+		backends: backends,
+		streams:  make(map[string]*upstreamStream),
+		subs:     make(map[string]*localSub),
+	}
+}
+
+func streamKeyFor(topic subscriptionTopic, filter interface{}) string {
+	filterJSON, _ := json.Marshal(filter)
+	return fmt.Sprintf("%s|%s", topic, filterJSON)
+}
+
+// Subscribe registers enqueue to receive notifications for topic/filter, dialing a new upstream subscription if no
+// client is already watching this exact (topic, filter) pair, and returns a local subscription id for
+// eth_unsubscribe.
+func (h *subscriptionHub) Subscribe(topic subscriptionTopic, filter interface{}, enqueue func([]byte)) (string, error) {
+	key := streamKeyFor(topic, filter)
+
+	h.mu.Lock()
+	stream, ok := h.streams[key]
+	h.mu.Unlock()
+
+	if !ok {
+		var err error
+		stream, err = h.dialStream(key, topic, filter)
+		if err != nil {
+			return "", err
+		}
+		h.mu.Lock()
+		// Another goroutine may have dialed the same stream concurrently; keep whichever won the race.
+		if existing, raced := h.streams[key]; raced {
+			stream.cancel()
+			stream = existing
+		} else {
+			h.streams[key] = stream
+		}
+		h.mu.Unlock()
+	}
+
+	subID := uuid.New().String()
+
+	stream.mu.Lock()
+	stream.subs[subID] = true
+	stream.mu.Unlock()
+
+	h.mu.Lock()
+	h.subs[subID] = &localSub{streamKey: key, enqueue: enqueue}
+	h.mu.Unlock()
+
+	return subID, nil
+}
+
+// Unsubscribe removes subID, tearing down its upstream stream once no local subscriber references it anymore.
+func (h *subscriptionHub) Unsubscribe(subID string) bool {
+	h.mu.Lock()
+	sub, ok := h.subs[subID]
+	if !ok {
+		h.mu.Unlock()
+		return false
+	}
+	delete(h.subs, subID)
+	stream := h.streams[sub.streamKey]
+	h.mu.Unlock()
+
+	if stream == nil {
+		return true
+	}
+
+	stream.mu.Lock()
+	delete(stream.subs, subID)
+	empty := len(stream.subs) == 0
+	stream.mu.Unlock()
+
+	if empty {
+		h.mu.Lock()
+		if h.streams[sub.streamKey] == stream {
+			delete(h.streams, sub.streamKey)
+		}
+		h.mu.Unlock()
+		stream.cancel()
+	}
+
+	return true
+}
+
+// fanOut delivers an upstream notification payload to every local subscriber currently referencing stream.
+func (h *subscriptionHub) fanOut(stream *upstreamStream, result json.RawMessage) {
+	stream.mu.Lock()
+	subIDs := make([]string, 0, len(stream.subs))
+	for id := range stream.subs {
+		subIDs = append(subIDs, id)
+	}
+	stream.mu.Unlock()
+
+	for _, subID := range subIDs {
+		h.mu.Lock()
+		sub, ok := h.subs[subID]
+		h.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		notification := struct {
+			Version string `json:"jsonrpc"`
+			Method  string `json:"method"`
+			Params  struct {
+				Subscription string          `json:"subscription"`
+				Result       json.RawMessage `json:"result"`
+			} `json:"params"`
+		}{Version: "2.0", Method: "eth_subscription"}
+		notification.Params.Subscription = subID
+		notification.Params.Result = result
+
+		sub.enqueue(mustMarshal(&notification))
+	}
+}
+
+// dialStream opens a single upstream WebSocket connection for topic/filter and starts a goroutine fanning its
+// notifications out via fanOut until the returned upstreamStream is cancelled.
+func (h *subscriptionHub) dialStream(key string, topic subscriptionTopic, filter interface{}) (*upstreamStream, error) {
+	backend, ok := h.backends.wsBackend()
+	if !ok {
+		return nil, fmt.Errorf("no backend in group %q has a websocket endpoint configured", h.backends.Name)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(backend.WsUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dial upstream ws %s: %w", backend.WsUrl, err)
+	}
+
+	params := []interface{}{string(topic)}
+	if filter != nil {
+		params = append(params, filter)
+	}
+	subscribeReq, _ := json.Marshal(JsonRpcRequest{Version: "2.0", Id: 1, Method: "eth_subscribe", Params: params})
+	if err := conn.WriteMessage(websocket.TextMessage, subscribeReq); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("subscribe upstream: %w", err)
+	}
+
+	// The first message on the connection is the eth_subscribe ack, not a notification.
+	_, ackMsg, err := conn.ReadMessage()
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("read upstream subscribe ack: %w", err)
+	}
+	var ack JsonRpcResponse
+	if err := json.Unmarshal(ackMsg, &ack); err != nil || ack.Error != nil {
+		_ = conn.Close()
+		if ack.Error != nil {
+			return nil, fmt.Errorf("upstream rejected subscribe: %s", ack.Error.Message)
+		}
+		return nil, fmt.Errorf("invalid upstream subscribe ack: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &upstreamStream{
+		key:    key,
+		topic:  topic,
+		filter: filter,
+		conn:   conn,
+		cancel: cancel,
+		subs:   make(map[string]bool),
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	go h.readUpstream(stream)
+
+	return stream, nil
+}
+
+func (h *subscriptionHub) readUpstream(stream *upstreamStream) {
+	for {
+		_, msg, err := stream.conn.ReadMessage()
+		if err != nil {
+			logger.Error().Str("stream", stream.key).Err(err).Msg("upstream ws subscription closed")
+			stream.cancel()
+			h.redial(stream)
+			return
+		}
+
+		var notification struct {
+			Method string `json:"method"`
+			Params struct {
+				Result json.RawMessage `json:"result"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(msg, &notification); err != nil || notification.Method != "eth_subscription" {
+			continue
+		}
+
+		h.fanOut(stream, notification.Params.Result)
+	}
+}
+
+// redial replaces a dead stream in h.streams with a freshly dialed one carrying over its existing local subscribers,
+// so a dropped upstream connection doesn't leave existing subscribers silently black-holed and future Subscribe
+// calls for the same topic/filter don't reuse the closed connection. If redialing fails, the dead entry is simply
+// removed: existing subscribers stop receiving notifications until they resubscribe, but at least a new Subscribe
+// call will dial a fresh connection instead of reusing the dead one.
+func (h *subscriptionHub) redial(stream *upstreamStream) {
+	h.mu.Lock()
+	current, ok := h.streams[stream.key]
+	if ok && current == stream {
+		delete(h.streams, stream.key)
+	}
+	h.mu.Unlock()
+	if !ok || current != stream {
+		return // already replaced/removed by someone else
+	}
+
+	stream.mu.Lock()
+	subIDs := make([]string, 0, len(stream.subs))
+	for id := range stream.subs {
+		subIDs = append(subIDs, id)
+	}
+	stream.mu.Unlock()
+	if len(subIDs) == 0 {
+		return // no one left subscribed; let the next Subscribe call dial fresh
+	}
+
+	newStream, err := h.dialStream(stream.key, stream.topic, stream.filter)
+	if err != nil {
+		logger.Error().Str("stream", stream.key).Err(err).Msg("failed to redial upstream ws subscription")
+		return
+	}
+
+	newStream.mu.Lock()
+	for _, id := range subIDs {
+		newStream.subs[id] = true
+	}
+	newStream.mu.Unlock()
+
+	h.mu.Lock()
+	h.streams[stream.key] = newStream
+	h.mu.Unlock()
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// traceIDKey is the context.Context key under which the request's trace ID is stored, so it threads through
+// dispatch, backend Forward calls, and the nonce-lookup path without every function needing a dedicated parameter.
+type traceIDKeyType struct{}
+
+var traceIDKey = traceIDKeyType{}
+
+// withTraceID returns a context carrying a trace ID, generating one if ctx doesn't already carry one.
+func withTraceID(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(traceIDKey).(string); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, traceIDKey, uuid.New().String())
+}
+
+// traceIDFromContext returns the trace ID carried by ctx, or "" if none was set.
+func traceIDFromContext(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey).(string)
+	return traceID
+}
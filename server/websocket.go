@@ -0,0 +1,297 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// subscriptionTopic identifies one of the upstream event streams a client can eth_subscribe to.
+type subscriptionTopic string
+
+const (
+	TopicNewHeads               subscriptionTopic = "newHeads"
+	TopicLogs                   subscriptionTopic = "logs"
+	TopicNewPendingTransactions subscriptionTopic = "newPendingTransactions"
+)
+
+var supportedSubscriptionTopics = map[subscriptionTopic]bool{
+	TopicNewHeads:               true,
+	TopicLogs:                   true,
+	TopicNewPendingTransactions: true,
+}
+
+const (
+	wsClientSendBuffer = 256              // per-client outbound queue depth before the client is considered too slow
+	wsWriteWait        = 10 * time.Second // time allowed to write a message to a client
+	wsPongWait         = 60 * time.Second // time allowed to read the next pong from a client
+	wsPingPeriod       = wsPongWait * 9 / 10
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true }, // RPC endpoint is meant to be embedded cross-origin
+}
+
+// WsHandler upgrades incoming HTTP connections to WebSocket and speaks JSON-RPC 2.0 over the resulting connection,
+// including eth_subscribe/eth_unsubscribe for newHeads, logs and newPendingTransactions. Non-subscription methods
+// are dispatched through RpcRequest.HandleSingle, the same pipeline the HTTP handler uses.
+type WsHandler struct {
+	primaryBackends   *BackendGroup
+	protectedBackends *BackendGroup
+	cache             *ResponseCache
+	limiter           RateLimiter // nil disables rate limiting
+	metrics           *Metrics    // nil disables metrics recording
+	hub               *subscriptionHub
+}
+
+func NewWsHandler(primaryBackends *BackendGroup, protectedBackends *BackendGroup, cache *ResponseCache, limiter RateLimiter, metrics *Metrics) *WsHandler {
+	return &WsHandler{
+		primaryBackends:   primaryBackends,
+		protectedBackends: protectedBackends,
+		cache:             cache,
+		limiter:           limiter,
+		metrics:           metrics,
+		hub:               newSubscriptionHub(primaryBackends),
+	}
+}
+
+func (h *WsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ip := GetIP(req)
+	if IsBlacklisted(ip) {
+		logger.Error().Str("ip", ip).Msg("ws connection rejected: blacklisted ip")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		logger.Error().Str("ip", ip).Err(err).Msg("ws upgrade failed")
+		return
+	}
+
+	uid := uuid.New().String()
+	client := &wsClient{
+		uid:  uid,
+		ip:   ip,
+		conn: conn,
+		hub:  h.hub,
+		send: make(chan []byte, wsClientSendBuffer),
+		rpcTemplate: &RpcRequest{
+			req:               req,
+			uid:               uid,
+			ip:                ip,
+			apiKey:            extractApiKey(req),
+			primaryBackends:   h.primaryBackends,
+			protectedBackends: h.protectedBackends,
+			cache:             h.cache,
+			limiter:           h.limiter,
+			metrics:           h.metrics,
+		},
+		subs: make(map[string]bool),
+	}
+	client.run()
+}
+
+// wsClient represents one connected WebSocket client: a reader goroutine (this handler's goroutine) parsing
+// incoming JSON-RPC frames, and a writer goroutine draining the bounded send queue so a slow reader on the client
+// side can't block fan-out to other clients.
+type wsClient struct {
+	uid  string
+	ip   string
+	conn *websocket.Conn
+	hub  *subscriptionHub
+	send chan []byte
+
+	// rpcTemplate carries the backends/cache shared by every request this client makes; HandleSingle is called
+	// against it per-message so state never leaks across concurrent messages.
+	rpcTemplate *RpcRequest
+
+	mu   sync.Mutex
+	subs map[string]bool // local subscription ids owned by this client, for cleanup on disconnect
+}
+
+func (c *wsClient) run() {
+	defer c.close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.writeLoop()
+	}()
+
+	c.conn.SetReadLimit(1 << 20)
+	_ = c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		c.handleMessage(msg)
+	}
+
+	close(c.send)
+	wg.Wait()
+}
+
+func (c *wsClient) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// enqueue delivers msg to the client's outbound queue without blocking; a client that can't keep up is disconnected
+// rather than allowed to stall fan-out to every other subscriber on the same topic.
+func (c *wsClient) enqueue(msg []byte) {
+	select {
+	case c.send <- msg:
+	default:
+		c.close()
+	}
+}
+
+func (c *wsClient) close() {
+	c.mu.Lock()
+	subs := make([]string, 0, len(c.subs))
+	for id := range c.subs {
+		subs = append(subs, id)
+	}
+	c.subs = make(map[string]bool)
+	c.mu.Unlock()
+
+	for _, id := range subs {
+		c.hub.Unsubscribe(id)
+	}
+	_ = c.conn.Close()
+}
+
+func (c *wsClient) handleMessage(msg []byte) {
+	var jsonReq JsonRpcRequest
+	if err := json.Unmarshal(msg, &jsonReq); err != nil {
+		c.enqueue(mustMarshal(&JsonRpcResponse{
+			Version: "2.0",
+			Error:   &JsonRpcError{Code: -32700, Message: "parse error"},
+		}))
+		return
+	}
+
+	switch jsonReq.Method {
+	case "eth_subscribe":
+		c.handleSubscribe(&jsonReq)
+	case "eth_unsubscribe":
+		c.handleUnsubscribe(&jsonReq)
+	default:
+		c.dispatchRpc(&jsonReq)
+	}
+}
+
+// dispatchRpc rate-limits and dispatches a single non-subscription JSON-RPC method through the same pipeline HTTP
+// uses (eth_sendRawTransaction routing, OFAC checks, intercepts, caching), recording the same requests_total metric
+// process() records for the HTTP path.
+func (c *wsClient) dispatchRpc(jsonReq *JsonRpcRequest) {
+	outcome := "dispatched"
+	defer func() { c.rpcTemplate.metrics.RecordRequest(jsonReq.Method, outcome) }()
+
+	if dec := c.rpcTemplate.checkRateLimit(jsonReq.Method, 1); !dec.Allowed {
+		outcome = "rate_limited"
+		c.enqueue(mustMarshal(errorResponse(jsonReq.Id, -32005, "limit exceeded")))
+		return
+	}
+
+	resp, err := c.rpcTemplate.HandleSingle(context.Background(), jsonReq)
+	if err != nil {
+		resp = &JsonRpcResponse{Id: jsonReq.Id, Version: "2.0", Error: &JsonRpcError{Code: -32603, Message: err.Error()}}
+		outcome = "error"
+	}
+	c.enqueue(mustMarshal(resp))
+}
+
+func (c *wsClient) handleSubscribe(jsonReq *JsonRpcRequest) {
+	if len(jsonReq.Params) < 1 {
+		c.enqueue(mustMarshal(errorResponse(jsonReq.Id, -32602, "eth_subscribe requires a topic")))
+		return
+	}
+	topicName, ok := jsonReq.Params[0].(string)
+	if !ok || !supportedSubscriptionTopics[subscriptionTopic(topicName)] {
+		c.enqueue(mustMarshal(errorResponse(jsonReq.Id, -32602, fmt.Sprintf("unsupported subscription topic %q", jsonReq.Params[0]))))
+		return
+	}
+
+	var filter interface{}
+	if len(jsonReq.Params) > 1 {
+		filter = jsonReq.Params[1]
+	}
+
+	subID, err := c.hub.Subscribe(subscriptionTopic(topicName), filter, c.enqueue)
+	if err != nil {
+		c.enqueue(mustMarshal(errorResponse(jsonReq.Id, -32000, err.Error())))
+		return
+	}
+
+	c.mu.Lock()
+	c.subs[subID] = true
+	c.mu.Unlock()
+
+	c.enqueue(mustMarshal(&JsonRpcResponse{Id: jsonReq.Id, Version: "2.0", Result: subID}))
+}
+
+func (c *wsClient) handleUnsubscribe(jsonReq *JsonRpcRequest) {
+	if len(jsonReq.Params) < 1 {
+		c.enqueue(mustMarshal(errorResponse(jsonReq.Id, -32602, "eth_unsubscribe requires a subscription id")))
+		return
+	}
+	subID, ok := jsonReq.Params[0].(string)
+	if !ok {
+		c.enqueue(mustMarshal(errorResponse(jsonReq.Id, -32602, "subscription id must be a string")))
+		return
+	}
+
+	c.mu.Lock()
+	delete(c.subs, subID)
+	c.mu.Unlock()
+
+	ok = c.hub.Unsubscribe(subID)
+	c.enqueue(mustMarshal(&JsonRpcResponse{Id: jsonReq.Id, Version: "2.0", Result: ok}))
+}
+
+func errorResponse(id interface{}, code int, msg string) *JsonRpcResponse {
+	return &JsonRpcResponse{Id: id, Version: "2.0", Error: &JsonRpcError{Code: code, Message: msg}}
+}
+
+func mustMarshal(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return []byte(`{"jsonrpc":"2.0","error":{"code":-32603,"message":"internal error"}}`)
+	}
+	return b
+}